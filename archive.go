@@ -0,0 +1,141 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lidder
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IsArchive tells whether filename is an archive Options.Archive can read,
+// judging by its extension
+func IsArchive(filename string) bool {
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz", ".zip"} {
+		if strings.HasSuffix(strings.ToLower(filename), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAgainstArchive scans the regular files within an archive, one at a
+// time as tarballs can only be read in order. Unlike files in the tree, the
+// archive itself is named as given rather than relative to the root.
+func (defs *Defs) matchAgainstArchive(archive string) error {
+	if strings.HasSuffix(strings.ToLower(archive), ".zip") {
+		return defs.matchAgainstZip(archive)
+	}
+
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if !strings.HasSuffix(strings.ToLower(archive), ".tar") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if defs.interrupted() {
+			return nil
+		}
+
+		err = defs.matchAgainstEntry(header.Name, header.Size, tr)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (defs *Defs) matchAgainstZip(archive string) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		if defs.interrupted() {
+			return nil
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = defs.matchAgainstEntry(f.Name, int64(f.UncompressedSize64), r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchAgainstEntry is matchAgainstFile for an archive entry, or a file held in
+// memory
+func (defs *Defs) matchAgainstEntry(name string, size int64, r io.Reader) error {
+	filename := filepath.FromSlash(strings.TrimPrefix(path.Clean(name), "/"))
+	if ok, reason := defs.checkFile(filename); !ok {
+		defs.logf("skipping %s: %s", filename, reason)
+		return nil
+	}
+	rules := defs.matchAgainstPath(defs.rulesFor(filename), filename)
+	if needsInterpreter(rules) {
+		reader := bufio.NewReaderSize(r, sniffLen)
+		head, err := reader.Peek(sniffLen)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		rules = withInterpreter(rules, interpreter(head))
+		r = reader
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	if defs.Options.MaxFileSize > 0 && size > defs.Options.MaxFileSize {
+		defs.logf("skipping %s: %d bytes is over the maximum file size", filename, size)
+		return nil
+	}
+
+	ctx, cancel := defs.fileContext()
+	defer cancel()
+	return defs.checkTimeout(filename, defs.matchAgainstReader(ctx, rules, filename, r, false))
+}
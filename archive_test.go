@@ -0,0 +1,107 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lidder
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/stretchr/testify/require"
+)
+
+var archiveEntries = []struct{ name, content string }{
+	{"./main.go", "package main\n\nfunc main() { panic(1) }\n"},
+	{"lib/lib.go", "package lib\n"},
+	{"lib/lib_test.go", "panic(1)\n"},
+	{"README.md", "panic(1)\n"},
+}
+
+func writeTar(w io.Writer) error {
+	tw := tar.NewWriter(w)
+	for _, entry := range archiveEntries {
+		err := tw.WriteHeader(&tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.content)), Typeflag: tar.TypeReg})
+		if err == nil {
+			_, err = tw.Write([]byte(entry.content))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func writeZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	for _, entry := range archiveEntries {
+		f, err := zw.Create(entry.name)
+		if err == nil {
+			_, err = f.Write([]byte(entry.content))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func writeTarGz(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	err := writeTar(gz)
+	if err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func (s *Zuite) TestMatchAgainstArchive() {
+	dir, err := ioutil.TempDir("", "lidder")
+	require.NoError(s.T(), err)
+	defer os.RemoveAll(dir)
+
+	for name, write := range map[string]func(io.Writer) error{
+		"build.tar":    writeTar,
+		"build.tar.gz": writeTarGz,
+		"build.zip":    writeZip,
+	} {
+		archive := filepath.Join(dir, name)
+		f, err := os.Create(archive)
+		require.NoError(s.T(), err)
+		require.NoError(s.T(), write(f))
+		require.NoError(s.T(), f.Close())
+		require.True(s.T(), IsArchive(archive))
+
+		d, err := Parse([]byte(`
+include: [\.go$]
+exclude: [_test\.go$]
+rules:
+  - pattern: panic\(
+    expected: [lib/lib.go]
+`))
+		require.NoError(s.T(), err)
+		d.Options.Archive = archive
+		results, err := Run(d, dir)
+		require.NoError(s.T(), err, name)
+
+		require.Equal(s.T(), 2, results.Stats.Files, name)
+		require.Equal(s.T(), []string{"main.go"}, results.Rules[0].ShouldNotBeThere, name)
+		require.Equal(s.T(), []string{"lib/lib.go"}, results.Rules[0].ShouldBeThere, name)
+		require.Equal(s.T(), []Match{{Line: 3, Text: "func main() { panic(1) }"}}, results.Rules[0].Matches["main.go"], name)
+	}
+
+	require.False(s.T(), IsArchive("main.go"))
+}
@@ -0,0 +1,217 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lidder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+)
+
+// RuleAudit lists the entries of a rule's Expected which are clutter
+type RuleAudit struct {
+	Rule *Rule
+	// files which don't exist under the root, having been deleted or
+	// renamed; globs aren't checked
+	Dead []string
+	// entries listed more than once, each reported once
+	Duplicates []string
+	// files the rule's include and exclude patterns keep from being
+	// scanned, so that the pattern can never be found in them
+	Excluded []string
+}
+
+// Audit finds the dead and duplicate Expected entries of each rule, files
+// being looked for under root. Rules without any are left out.
+func Audit(defs *Defs, root string) ([]*RuleAudit, error) {
+	err := defs.rebaseExpected(root)
+	if err != nil {
+		return nil, err
+	}
+	var audits []*RuleAudit
+	for _, rule := range defs.Rules {
+		audit := &RuleAudit{Rule: rule}
+		seen := make(map[string]int)
+		for _, expected := range rule.Expected {
+			// ./a.go and a.go are the same file
+			seen[filepath.Clean(expected)]++
+			if seen[filepath.Clean(expected)] == 2 {
+				audit.Duplicates = append(audit.Duplicates, expected)
+			}
+			if seen[filepath.Clean(expected)] > 1 || strings.ContainsAny(expected, "*?[") {
+				continue
+			}
+
+			path := expected
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(root, path)
+			}
+			_, err := os.Stat(path)
+			if os.IsNotExist(err) {
+				audit.Dead = append(audit.Dead, expected)
+			} else if err != nil {
+				return nil, err
+			}
+		}
+
+		audit.Excluded = rule.excludedExpected()
+		if len(audit.Dead) != 0 || len(audit.Duplicates) != 0 || len(audit.Excluded) != 0 {
+			audits = append(audits, audit)
+		}
+	}
+	return audits, nil
+}
+
+// Unscannable is Audit for the excluded Expected files alone, which doesn't
+// need to look at the files under root
+func Unscannable(defs *Defs, root string) ([]*RuleAudit, error) {
+	err := defs.rebaseExpected(root)
+	if err != nil {
+		return nil, err
+	}
+	var audits []*RuleAudit
+	for _, rule := range defs.Rules {
+		if excluded := rule.excludedExpected(); len(excluded) != 0 {
+			audits = append(audits, &RuleAudit{Rule: rule, Excluded: excluded})
+		}
+	}
+	return audits, nil
+}
+
+// excludedExpected lists the files of Expected which the rule doesn't scan,
+// globs and the directories of dir rules aside. A rule without any include
+// scans nothing at all, which isn't down to its expected files
+func (rule *Rule) excludedExpected() []string {
+	if rule.Scope == "dir" || len(rule.include) == 0 {
+		return nil
+	}
+	var excluded []string
+	for _, expected := range rule.Expected {
+		if strings.ContainsAny(expected, "*?[") {
+			continue
+		}
+		if !rule.shouldCheck(filepath.Clean(expected)) {
+			excluded = append(excluded, expected)
+		}
+	}
+	return excluded
+}
+
+// RuleOverlap is a pair of rules matching the same lines, as Overlaps finds,
+// which reports them twice when both fail
+type RuleOverlap struct {
+	Rule  *Rule
+	Other *Rule
+	// the patterns are the same once parsed and the rules filter the lines
+	// they match alike, or else every line Rule matches, Other matches too
+	Identical bool
+	// the rules expect different files, so that one reports what the other
+	// allows
+	Conflicting bool
+}
+
+// Overlaps finds the rules whose patterns are the same, or which are plain
+// strings one containing the other, without scanning anything. Include and
+// exclude lists which keep the rules apart aren't looked at, so that
+// overlaps are only likely.
+func Overlaps(defs *Defs) []*RuleOverlap {
+	var overlaps []*RuleOverlap
+	for i, rule := range defs.Rules {
+		for _, other := range defs.Rules[i+1:] {
+			if rule.Target != other.Target || rule.ordered != nil || other.ordered != nil {
+				continue
+			}
+			overlap := &RuleOverlap{Rule: rule, Other: other}
+			switch {
+			case canonical(rule.pattern) == canonical(other.pattern) && sameFilters(rule, other):
+				overlap.Identical = true
+			case shadows(rule, other):
+			case shadows(other, rule):
+				overlap.Rule, overlap.Other = other, rule
+			default:
+				continue
+			}
+			overlap.Conflicting = !sameExpected(rule, other)
+			overlaps = append(overlaps, overlap)
+		}
+	}
+	return overlaps
+}
+
+// canonical is pattern as written once simplified, so that the likes of a\.b
+// and a[.]b compare equal
+func canonical(pattern *regexp.Regexp) string {
+	re, err := syntax.Parse(pattern.String(), syntax.Perl)
+	if err != nil {
+		return pattern.String()
+	}
+	return re.Simplify().String()
+}
+
+// shadows tells whether rule's pattern is a plain string containing that of
+// other, which then matches every line rule does
+func shadows(rule, other *Rule) bool {
+	if other.unless != nil || lineFilters(rule) != lineFilters(other) {
+		return false
+	}
+	literal, ok := literalOf(rule.pattern)
+	if !ok {
+		return false
+	}
+	otherLiteral, ok := literalOf(other.pattern)
+	return ok && strings.Contains(literal, otherLiteral)
+}
+
+// sameFilters tells whether rule and other keep the same lines out of those
+// their patterns match
+func sameFilters(rule, other *Rule) bool {
+	if (rule.unless == nil) != (other.unless == nil) {
+		return false
+	}
+	if rule.unless != nil && rule.unless.String() != other.unless.String() {
+		return false
+	}
+	return lineFilters(rule) == lineFilters(other)
+}
+
+// lineFilters sums up the options, unless aside, which decide what lines and
+// files a rule's pattern is matched against
+func lineFilters(rule *Rule) string {
+	return fmt.Sprintf("%t %q %t %d %q %q", rule.SkipComments, rule.CommentPrefix, rule.Multiline,
+		rule.MaxLines, rule.Shebang, rule.Preprocess)
+}
+
+func sameExpected(rule, other *Rule) bool {
+	expected := func(rule *Rule) []string {
+		cleaned := make([]string, len(rule.Expected))
+		for i, entry := range rule.Expected {
+			cleaned[i] = filepath.Clean(entry)
+		}
+		sort.Strings(cleaned)
+		return cleaned
+	}
+	a, b := expected(rule), expected(other)
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
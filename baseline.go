@@ -0,0 +1,91 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lidder
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Baseline grandfathers the unexpected matches which existed when it was
+// written, so that only new ones fail the lid test
+type Baseline struct {
+	Rules []*BaselineRule
+}
+
+// BaselineRule is the files a rule's pattern was unexpectedly found in
+type BaselineRule struct {
+	Pattern string
+	Files   []string
+}
+
+// ReadBaseline reads a baseline written by WriteBaseline
+func ReadBaseline(filename string) (*Baseline, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var b Baseline
+	err = yaml.Unmarshal(content, &b)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// WriteBaseline records the unexpected matches of results
+func WriteBaseline(filename string, results Results) error {
+	var b Baseline
+	for _, result := range results.Rules {
+		if len(result.ShouldNotBeThere) == 0 {
+			continue
+		}
+		files := append([]string(nil), result.ShouldNotBeThere...)
+		sort.Strings(files)
+		b.Rules = append(b.Rules, &BaselineRule{result.Rule.Pattern, files})
+	}
+
+	content, err := yaml.Marshal(&b)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, content, 0644)
+}
+
+// applyBaseline forgets the matches grandfathered by b, rules being
+// identified by their pattern
+func (defs *Defs) applyBaseline(b *Baseline) {
+	grandfathered := make(map[string]map[string]bool)
+	for _, r := range b.Rules {
+		if grandfathered[r.Pattern] == nil {
+			grandfathered[r.Pattern] = make(map[string]bool)
+		}
+		for _, filename := range r.Files {
+			grandfathered[r.Pattern][filepath.Clean(filename)] = true
+		}
+	}
+
+	for _, rule := range defs.Rules {
+		for filename := range grandfathered[rule.Pattern] {
+			if !rule.isExpected(filename) {
+				delete(rule.actualFilenames, filename)
+				delete(rule.matches, filename)
+				delete(rule.matchCounts, filename)
+			}
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lidder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// bumped whenever what the cache holds changes
+const cacheVersion = 1
+
+// cache remembers what the rules matched in each file, so that files which
+// haven't changed since the last run needn't be scanned again. It's only good
+// for the config and options it was written with, which its key sums up.
+type cache struct {
+	Key   string
+	Files map[string]*cachedFile
+
+	// the entries for the files of this run, which replace Files once it's
+	// written back
+	fresh map[string]*cachedFile
+}
+
+// cachedFile is what the content rules made of a file, as of its ModTime and
+// Size
+type cachedFile struct {
+	ModTime time.Time
+	Size    int64
+	// false for files skipped as binary
+	Scanned bool
+	// by index within Defs.Rules, only for the rules which matched
+	Rules map[int]*cachedMatches `json:",omitempty"`
+}
+
+type cachedMatches struct {
+	Count   int
+	Matches []Match
+}
+
+// cacheKey sums up what makes matches differ for the same files: the config
+// as parsed, the root, the options which change what's recorded and the
+// paths each rule applies to, which AddPaths, Extensions and .lidderignore
+// change on top of the config
+func (defs *Defs) cacheKey() (string, error) {
+	config, err := yaml.Marshal(defs)
+	if err != nil {
+		return "", err
+	}
+	root, err := filepath.Abs(defs.root)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n%s\n%s\n", cacheVersion, root, config)
+	fmt.Fprintf(h, "%t %d %t\n", defs.Options.AllMatches, defs.Options.Context, defs.Options.IncludeBinary)
+	for _, rule := range defs.Rules {
+		include, exclude := rule.Paths()
+		fmt.Fprintf(h, "%q %q", include, exclude)
+		if rule.extension != nil {
+			fmt.Fprintf(h, " %q", rule.extension)
+		}
+		fmt.Fprintln(h)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readCache reads the cache at filename, starting over when it's missing,
+// unreadable or was written for another config
+func (defs *Defs) readCache(filename string) (*cache, error) {
+	key, err := defs.cacheKey()
+	if err != nil {
+		return nil, err
+	}
+	c := &cache{Key: key, fresh: make(map[string]*cachedFile)}
+
+	content, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var previous cache
+	if err := json.Unmarshal(content, &previous); err != nil {
+		defs.logf("not using the cache %s: %s", filename, err)
+		return c, nil
+	}
+	if previous.Key != key {
+		defs.logf("not using the cache %s: written for another config", filename)
+		return c, nil
+	}
+	c.Files = previous.Files
+	return c, nil
+}
+
+// writeCache writes the entries of this run to filename
+func (defs *Defs) writeCache(filename string) error {
+	content, err := json.Marshal(&cache{Key: defs.cache.Key, Files: defs.cache.fresh})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, content, 0644)
+}
+
+// restoreCached records the matches cached for filename as if it was scanned
+// again by rules, those which apply to it, telling whether it was, which it
+// isn't when it changed since
+func (defs *Defs) restoreCached(rules []*Rule, filename string, fi os.FileInfo) bool {
+	defs.mu.Lock()
+	defer defs.mu.Unlock()
+
+	entry := defs.cache.Files[filename]
+	if entry == nil || !entry.ModTime.Equal(fi.ModTime()) || entry.Size != fi.Size() {
+		return false
+	}
+	for i := range entry.Rules {
+		if i < 0 || i >= len(defs.Rules) {
+			return false
+		}
+	}
+
+	defs.logf("%s unchanged since cached", filename)
+	defs.cache.fresh[filename] = entry
+	defs.scanned.Cached++
+	if !entry.Scanned {
+		return true
+	}
+	applies := make(map[*Rule]bool, len(rules))
+	for _, rule := range rules {
+		applies[rule] = true
+		rule.scannedFilenames[rule.unit(filename)] = true
+	}
+	for i, cached := range entry.Rules {
+		rule := defs.Rules[i]
+		if !applies[rule] {
+			continue
+		}
+		rule.actualFilenames[filename] = true
+		rule.matchCounts[filename] = cached.Count
+		rule.matches[filename] = cached.Matches
+	}
+	return true
+}
+
+// storeCached records what the content rules matched in filename, which was
+// just scanned, for the next run
+func (defs *Defs) storeCached(rules []*Rule, filename string, fi os.FileInfo) {
+	defs.mu.Lock()
+	defer defs.mu.Unlock()
+
+	// the rules are all marked scanned at once, unless the file is binary
+	entry := &cachedFile{ModTime: fi.ModTime(), Size: fi.Size(), Scanned: rules[0].scannedFilenames[filename]}
+	for i, rule := range defs.Rules {
+		if rule.Target == "path" || rule.matchCounts[filename] == 0 {
+			continue
+		}
+		if entry.Rules == nil {
+			entry.Rules = make(map[int]*cachedMatches)
+		}
+		entry.Rules[i] = &cachedMatches{rule.matchCounts[filename], rule.matches[filename]}
+	}
+	defs.cache.fresh[filename] = entry
+}
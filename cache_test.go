@@ -0,0 +1,117 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lidder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func (s *Zuite) TestCache() {
+	root := writeTree(s.T(), map[string]string{
+		"a.go":        "panic(1)\n",
+		"b.go":        "ok\n",
+		"expected.go": "panic(2)\n",
+	})
+	defer os.RemoveAll(root)
+	cacheFile := filepath.Join(root, ".lidder-cache")
+
+	run := func(config string) Results {
+		d, err := Parse([]byte(config))
+		require.NoError(s.T(), err)
+		d.Options.Cache = cacheFile
+		results, err := Run(d, root)
+		require.NoError(s.T(), err)
+		return results
+	}
+	config := "include: [\\.go$]\nrules:\n  - pattern: panic\n    expected: [expected.go]\n"
+
+	results := run(config)
+	require.Equal(s.T(), 3, results.Stats.Files)
+	require.Equal(s.T(), 0, results.Stats.Cached)
+	require.Equal(s.T(), []string{"a.go"}, results.Rules[0].ShouldNotBeThere)
+
+	results = run(config)
+	require.Equal(s.T(), 0, results.Stats.Files)
+	require.Equal(s.T(), 3, results.Stats.Cached)
+	require.Equal(s.T(), 3, results.Stats.Checked)
+	require.Equal(s.T(), []string{"a.go"}, results.Rules[0].ShouldNotBeThere)
+	require.Empty(s.T(), results.Rules[0].ShouldBeThere)
+	require.Equal(s.T(), []Match{{Line: 1, Text: "panic(1)"}}, results.Rules[0].Matches["a.go"])
+
+	// a changed file is scanned again
+	a := filepath.Join(root, "a.go")
+	require.NoError(s.T(), ioutil.WriteFile(a, []byte("ok\n"), 0644))
+	later := time.Now().Add(time.Minute)
+	require.NoError(s.T(), os.Chtimes(a, later, later))
+	results = run(config)
+	require.Equal(s.T(), 1, results.Stats.Files)
+	require.Equal(s.T(), 2, results.Stats.Cached)
+	require.Empty(s.T(), results.Rules[0].ShouldNotBeThere)
+
+	// files the config doesn't apply to aren't checked at all
+	results = run("include: [\\.rs$]\nrules:\n  - pattern: panic\n")
+	require.Equal(s.T(), 0, results.Stats.Checked)
+
+	// and every file is scanned again once the config changes
+	results = run(config + "  - pattern: ok\n")
+	require.Equal(s.T(), 3, results.Stats.Files)
+	require.Equal(s.T(), 0, results.Stats.Cached)
+	require.Equal(s.T(), []string{"a.go", "b.go"}, results.Rules[1].ShouldNotBeThere)
+}
+
+func (s *Zuite) TestCacheAfterScopeChange() {
+	root := writeTree(s.T(), map[string]string{
+		"a.go":      "ok\n",
+		"notes.txt": "foo bar\n",
+	})
+	defer os.RemoveAll(root)
+	cacheFile := filepath.Join(root, ".lidder-cache")
+
+	run := func(include []string) Results {
+		d, err := Parse([]byte(`
+include: [\.go$]
+rules:
+  - own:
+    pattern: foo
+    include: [\.txt$]
+    expected: [notes.txt]
+  - global:
+    pattern: bar
+`))
+		require.NoError(s.T(), err)
+		require.NoError(s.T(), d.AddPaths(include, nil))
+		d.Options.Cache = cacheFile
+		results, err := Run(d, root)
+		require.NoError(s.T(), err)
+		return results
+	}
+
+	results := run([]string{`\.txt$`})
+	require.Equal(s.T(), []string{"notes.txt"}, results.Rules[1].ShouldNotBeThere)
+
+	// the global rule no longer applies to notes.txt, whatever the cache
+	// says it matched there
+	results = run(nil)
+	require.Empty(s.T(), results.Rules[1].ShouldNotBeThere)
+	require.Empty(s.T(), results.Rules[0].ShouldBeThere)
+
+	results = run(nil)
+	require.Equal(s.T(), 2, results.Stats.Cached)
+	require.Empty(s.T(), results.Rules[1].ShouldNotBeThere)
+	require.Empty(s.T(), results.Rules[0].ShouldBeThere)
+}
@@ -0,0 +1,51 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/helloeave/lidder"
+)
+
+// printFiles writes the files any rule didn't expect to find its pattern in,
+// one per line, sorted and each only once, so as to be piped to xargs or an
+// editor
+func printFiles(out io.Writer, results []*lidder.RuleResult) error {
+	seen := make(map[string]bool)
+	for _, result := range results {
+		for _, s := range result.ShouldNotBeThere {
+			matches := result.Matches[s]
+			if len(matches) == 0 {
+				seen[s] = true
+			}
+			for _, m := range matches {
+				seen[matchFile(s, m)] = true
+			}
+		}
+	}
+	filenames := make([]string, 0, len(seen))
+	for filename := range seen {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	w := bufio.NewWriter(out)
+	for _, filename := range filenames {
+		fmt.Fprintln(w, filename)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,56 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// changedFiles lists the files under root which changed since ref forked off,
+// as of the working tree, relative to root. Deleted files are left out.
+func changedFiles(root, ref string) ([]string, error) {
+	base, err := git(root, "merge-base", ref, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	out, err := git(root, "diff", "--name-only", "--relative", "--diff-filter=d", "-z", strings.TrimSpace(base))
+	if err != nil {
+		return nil, err
+	}
+
+	filenames := []string{}
+	for _, filename := range strings.Split(out, "\x00") {
+		if filename != "" {
+			filenames = append(filenames, filename)
+		}
+	}
+	return filenames, nil
+}
+
+// git runs a git command within dir, returning its output
+func git(dir string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %s", args[0], err)
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,90 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/helloeave/lidder"
+)
+
+// githubCommands map rule severities to the GitHub Actions workflow commands
+// annotating them, see
+// https://docs.github.com/en/actions/using-workflows/workflow-commands-for-github-actions
+var githubCommands = map[string]string{"error": "error", "warning": "warning", "info": "notice"}
+
+var (
+	// githubDataEscaper keeps a message on the line of its command
+	githubDataEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	// githubPropertyEscaper also keeps a property from running into the
+	// next one
+	githubPropertyEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+)
+
+// printGitHub writes a workflow command per mismatch, which GitHub Actions
+// shows as annotations, then the summary of the text format
+func printGitHub(out io.Writer, results []*lidder.RuleResult, checked int, ok, partial bool) error {
+	w := bufio.NewWriter(out)
+	failed, unexpected, missing := 0, 0, 0
+	for _, result := range results {
+		if !result.Failed() {
+			continue
+		}
+		failed++
+		rule := result.Rule
+		annotate := func(filename string, line int, message string) {
+			var properties []string
+			if filename != "" {
+				properties = append(properties, "file="+githubPropertyEscaper.Replace(filepath.ToSlash(filename)))
+			}
+			if line > 0 {
+				properties = append(properties, fmt.Sprintf("line=%d", line))
+			}
+			properties = append(properties, "title="+githubPropertyEscaper.Replace(rule.Title()))
+			if rule.Message != "" {
+				message += "\n" + rule.Message
+			}
+			fmt.Fprintf(w, "::%s %s::%s\n", githubCommands[rule.SeverityOrDefault()], strings.Join(properties, ","), githubDataEscaper.Replace(message))
+		}
+
+		for _, filename := range result.ShouldNotBeThere {
+			for _, m := range result.Matches[filename] {
+				annotate(matchFile(filename, m), m.Line, fmt.Sprintf("Lidded pattern '%s' found", rule.Title()))
+			}
+		}
+		for _, filename := range result.ShouldBeThere {
+			annotate(filename, 0, fmt.Sprintf("Lidded pattern '%s' expected but not found", rule.Title()))
+		}
+		if note := fileCountNote(result); note != "" {
+			annotate("", 0, fmt.Sprintf("Lidded pattern '%s' %s", rule.Title(), note))
+		}
+		unexpected += len(result.ShouldNotBeThere)
+		missing += len(result.ShouldBeThere)
+	}
+
+	if !ok {
+		fmt.Fprintf(w, "%d of %d rules failed across %s: %d unexpected matches, %d missing expected exceptions\n",
+			failed, len(results), files(checked), unexpected, missing)
+		fmt.Fprintln(w, "lid test failed. sorry.")
+	}
+	if partial {
+		fmt.Fprintf(w, "::warning::interrupted after checking %s, these results are partial\n", files(checked))
+	} else if ok {
+		fmt.Fprintf(w, "ok\tlid on all the things across %s, nothing to see here.\n", files(checked))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,154 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"html/template"
+	"io"
+	"strings"
+
+	"github.com/helloeave/lidder"
+)
+
+type htmlReport struct {
+	OK      bool
+	Partial bool
+	Checked string
+	Total   int
+	Rules   []*htmlRule
+}
+
+type htmlRule struct {
+	Title      string
+	Message    string
+	Pattern    string
+	Severity   string
+	RequireAll bool
+	Heading    string
+	Unexpected []htmlMatch
+	Missing    []string
+	Note       string
+}
+
+type htmlMatch struct {
+	Location string
+	Text     string
+}
+
+// the report is a single page, styles included, so that it can be passed
+// around as is
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>lidder report</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; color: #24292f; max-width: 60em; margin: 2em auto; padding: 0 1em; }
+h1 { font-size: 1.5em; }
+h2 { font-size: 1.15em; margin: 0 0 .5em; }
+code { font-family: SFMono-Regular, Consolas, Menlo, monospace; font-size: .9em; }
+.summary { padding: .75em 1em; border-radius: 6px; }
+.ok { background: #dafbe1; }
+.failed { background: #ffebe9; }
+.partial { background: #fff8c5; }
+section { border: 1px solid #d0d7de; border-left: 4px solid #cf222e; border-radius: 6px; padding: 1em; margin: 1em 0; }
+section.warning { border-left-color: #bf8700; }
+section.info { border-left-color: #0969da; }
+.severity { font-size: .75em; font-weight: normal; text-transform: uppercase; color: #57606a; margin-left: .5em; }
+.pattern, .note { color: #57606a; }
+summary { cursor: pointer; font-weight: 600; margin: .5em 0; }
+ul { margin: 0; padding-left: 1.5em; }
+li { margin: .25em 0; }
+li .text { color: #57606a; margin-left: 1em; }
+</style>
+</head>
+<body>
+<h1>lidder report</h1>
+{{if not .OK -}}
+<p class="summary failed">{{len .Rules}} of {{.Total}} rules failed across {{.Checked}}.</p>
+{{- end}}
+{{if .Partial -}}
+<p class="summary partial">Interrupted after checking {{.Checked}}, these results are partial.</p>
+{{- else if .OK -}}
+<p class="summary ok">Lid on all the things across {{.Checked}}, nothing to see here.</p>
+{{- end}}
+{{range .Rules}}
+<section class="{{.Severity}}">
+<h2>{{.Title}}<span class="severity">{{.Severity}}</span></h2>
+{{with .Message}}<p>{{.}}</p>
+{{end -}}
+<p class="pattern">Pattern <code>{{.Pattern}}</code></p>
+{{- if .Unexpected}}
+<details open>
+<summary>{{.Heading}} ({{len .Unexpected}})</summary>
+<ul>
+{{- range .Unexpected}}
+<li><code>{{.Location}}</code>{{with .Text}}<code class="text">{{.}}</code>{{end}}</li>
+{{- end}}
+</ul>
+</details>
+{{- end}}
+{{- if .Missing}}
+<details open>
+<summary>{{if .RequireAll}}Expected to find but missing from{{else}}Expected exceptions which were missing{{end}} ({{len .Missing}})</summary>
+<ul>
+{{- range .Missing}}
+<li><code>{{.}}</code></li>
+{{- end}}
+</ul>
+</details>
+{{- end}}
+{{with .Note}}<p class="note">{{.}}</p>
+{{end -}}
+</section>
+{{end -}}
+</body>
+</html>
+`))
+
+func capitalize(s string) string {
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// printHTML renders a standalone page grouping the mismatches of each failed
+// rule, for those who'd rather not read the text output
+func printHTML(w io.Writer, results []*lidder.RuleResult, checked int, ok, partial bool) error {
+	report := htmlReport{OK: ok, Partial: partial, Checked: files(checked), Total: len(results)}
+	for _, result := range results {
+		if !result.Failed() {
+			continue
+		}
+		rule := &htmlRule{
+			Title:      result.Rule.Title(),
+			Message:    result.Rule.Message,
+			Pattern:    result.Rule.Pattern,
+			Severity:   result.Rule.SeverityOrDefault(),
+			RequireAll: result.Rule.RequireAll,
+			Heading:    capitalize(unexpectedHeading(result.Rule)),
+			Missing:    result.ShouldBeThere,
+			Note:       fileCountNote(result),
+		}
+		for _, s := range result.ShouldNotBeThere {
+			for _, m := range result.Matches[s] {
+				match := htmlMatch{Location: location(s, m)}
+				// path rules' text is the path again
+				if m.Line > 0 {
+					match.Text = m.Text
+				}
+				rule.Unexpected = append(rule.Unexpected, match)
+			}
+		}
+		report.Rules = append(report.Rules, rule)
+	}
+	return htmlTemplate.Execute(w, report)
+}
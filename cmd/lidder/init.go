@@ -0,0 +1,121 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// the config -init writes
+const initFilename = "lidder.yaml"
+
+// the configs used when none is given, in order of preference
+var defaultConfigs = []string{initFilename, ".lidder.yaml"}
+
+// initConfig is a starter config showing the most common fields
+const initConfig = `# lidder config: each rule lids a pattern, which may only be found in the
+# files it expects. Run it with: lidder
+
+# the files to scan, as regexes on their path relative to the root, or as
+# globs with pattern_syntax: glob
+include:
+  - \.go$
+# files to leave out, even when included
+exclude:
+  - ^vendor/
+  - _test\.go$
+
+rules:
+  # a label names the rule in reports
+  - no exiting outside of main:
+    # a regex matched against each line of the included files
+    pattern: os\.Exit\(
+    # why the pattern is lidded, shown along with where it was found
+    message: return errors instead, main decides when to exit
+    # the only files where the pattern may be, and must be, found; globs
+    # such as cmd/*/main.go are allowed
+    expected:
+      - main.go
+
+  - plain http:
+    pattern: http://
+    # lines which also match this aren't matches
+    unless: http://(localhost|127\.0\.0\.1)[:/]
+    # warnings and info are reported without failing, unless -fail-level
+    # says otherwise
+    severity: warning
+`
+
+// writeInitConfig writes initConfig to initFilename, only overwriting it
+// with force
+func writeInitConfig(force bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !force {
+		flags |= os.O_EXCL
+	}
+	file, err := os.OpenFile(initFilename, flags, 0644)
+	if os.IsExist(err) {
+		return fmt.Errorf("%s already exists, use -force to overwrite it", initFilename)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(initConfig)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// looksLikeConfig tells whether the first argument is a config rather than a
+// path to scan, going by its extension since a config may not exist locally.
+// A config read from stdin still comes first, as it always did.
+func looksLikeConfig(arg string) bool {
+	if arg == "-" || strings.Contains(arg, "://") {
+		return true
+	}
+	switch filepath.Ext(arg) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// findConfig looks for one of defaultConfigs in dir, then in its parents up
+// to the root of the git repository it's in, if any. It's empty when there's
+// none.
+func findConfig(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		for _, name := range defaultConfigs {
+			filename := filepath.Join(dir, name)
+			if fi, err := os.Stat(filename); err == nil && !fi.IsDir() {
+				return filename, nil
+			}
+		}
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return "", nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
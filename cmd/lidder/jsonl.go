@@ -0,0 +1,85 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+
+	"github.com/helloeave/lidder"
+)
+
+// jsonlRecord is a line of the jsonl format: an unexpected match, an
+// expected file where the pattern was missing, or the number of files it was
+// found in when out of the rule's min_files to max_files range
+type jsonlRecord struct {
+	Type     string `json:"type"`
+	Rule     string `json:"rule"`
+	Pattern  string `json:"pattern"`
+	Severity string `json:"severity"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Files    int    `json:"files,omitempty"`
+}
+
+// printJSONL writes a JSON object per line for each mismatch, flushing them
+// rule by rule so that consumers can process them as they come
+func printJSONL(out io.Writer, results []*lidder.RuleResult) error {
+	w := bufio.NewWriter(out)
+	encoder := json.NewEncoder(w)
+	for _, result := range results {
+		rule := result.Rule
+		name := rule.Name
+		if name == "" {
+			name = rule.Pattern
+		}
+		record := func(typ, filename string) *jsonlRecord {
+			return &jsonlRecord{Type: typ, Rule: name, Pattern: rule.Pattern, Severity: rule.SeverityOrDefault(), File: filename}
+		}
+
+		for _, filename := range result.ShouldNotBeThere {
+			for _, m := range result.Matches[filename] {
+				r := record("unexpected", matchFile(filename, m))
+				r.Line, r.Text = m.Line, m.Text
+				if err := encoder.Encode(r); err != nil {
+					return err
+				}
+			}
+		}
+		for _, filename := range result.ShouldBeThere {
+			if err := encoder.Encode(record("missing", filename)); err != nil {
+				return err
+			}
+		}
+		if result.Shortfall != 0 {
+			r := record("min_files", "")
+			r.Files = rule.MinFiles - result.Shortfall
+			if err := encoder.Encode(r); err != nil {
+				return err
+			}
+		}
+		if result.Excess != 0 {
+			r := record("max_files", "")
+			r.Files = rule.MaxFiles + result.Excess
+			if err := encoder.Encode(r); err != nil {
+				return err
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+
+	"github.com/helloeave/lidder"
+)
+
+type junitTestSuite struct {
+	XMLName   xml.Name         `xml:"testsuite"`
+	Name      string           `xml:"name,attr"`
+	Tests     int              `xml:"tests,attr"`
+	Failures  int              `xml:"failures,attr"`
+	Errors    int              `xml:"errors,attr"`
+	TestCases []*junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message  string `xml:"message,attr"`
+	Type     string `xml:"type,attr"`
+	Contents string `xml:",cdata"`
+}
+
+// printJUnit reports the run as one test suite, with a test case per rule
+func printJUnit(w io.Writer, results []*lidder.RuleResult) error {
+	suite := junitTestSuite{Name: "lidder", Tests: len(results)}
+	for _, result := range results {
+		testCase := &junitTestCase{Name: result.Rule.Pattern, ClassName: "lidder"}
+		if result.Failed() {
+			suite.Failures++
+
+			var contents bytes.Buffer
+			if len(result.ShouldNotBeThere) != 0 {
+				fmt.Fprintf(&contents, "%s:\n", unexpectedHeading(result.Rule))
+				for _, s := range result.ShouldNotBeThere {
+					for _, m := range result.Matches[s] {
+						fmt.Fprintf(&contents, " - %s\n", location(s, m))
+					}
+				}
+			}
+			if len(result.ShouldBeThere) != 0 {
+				fmt.Fprintln(&contents, "expected exceptions which were missing:")
+				for _, s := range result.ShouldBeThere {
+					fmt.Fprintf(&contents, " - %s\n", s)
+				}
+			}
+			if note := fileCountNote(result); note != "" {
+				fmt.Fprintln(&contents, note)
+			}
+			testCase.Failure = &junitFailure{
+				Message: fmt.Sprintf("%d unexpected matches, %d missing expected exceptions",
+					len(result.ShouldNotBeThere), len(result.ShouldBeThere)),
+				Type:     result.Rule.SeverityOrDefault(),
+				Contents: contents.String(),
+			}
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+
+	fmt.Fprint(w, xml.Header)
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	err := encoder.Encode(suite)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+	return nil
+}
@@ -0,0 +1,1200 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/helloeave/lidder"
+)
+
+var (
+	format          = flag.String("format", "text", "output format: text, json, jsonl, sarif, junit, html for a standalone page, github for GitHub Actions annotations, files for the bare list of files with unexpected matches, or tsv, which has a line per rule with its pattern, expected, actual, unexpected and missing file counts")
+	out             = flag.String("out", "", "write the report to this file rather than stdout, errors and warnings still going to stderr")
+	color           = flag.String("color", "auto", "color the text output: auto, always or never")
+	progress        = flag.String("progress", "auto", "report the files scanned so far to stderr during long scans: auto, always or never")
+	jobs            = flag.Int("jobs", runtime.NumCPU(), "number of files to scan concurrently")
+	gitignore       = flag.Bool("gitignore", false, "skip files and directories ignored by .gitignore files")
+	allMatches      = flag.Bool("all-matches", false, "report every matching line instead of the first one per file")
+	root            = flag.String("root", ".", "directory to scan, which files and Expected entries are relative to")
+	since           = flag.String("since", "", "only scan the files changed since the working tree forked off this git ref, such as origin/main")
+	watch           = flag.Bool("watch", false, "keep running, scanning again whenever files under the root or the config change")
+	stdin           = flag.Bool("stdin", false, "read the files to scan from stdin, one per line")
+	verbose         = flag.Bool("v", false, "log the directories and files scanned or skipped, and matches, to stderr")
+	includeBinary   = flag.Bool("include-binary", false, "scan files which look binary too")
+	baselineFile    = flag.String("baseline", "", "baseline file listing grandfathered unexpected matches")
+	checkConfig     = flag.Bool("check-config", false, "only check that the config is valid, expected files included, warning of rules matching the same lines")
+	followSymlinks  = flag.Bool("follow-symlinks", false, "follow symlinks to files and directories, which are skipped otherwise")
+	hidden          = flag.Bool("hidden", false, "scan hidden files and directories, those whose name starts with a dot, which are skipped otherwise")
+	strictEnv       = flag.Bool("strict-env", false, "fail when the config references unset environment variables")
+	failLevel       = flag.String("fail-level", "error", "lowest rule severity which fails the lid test: error, warning or info")
+	failOn          = flag.String("fail-on", "both", "which mismatches fail the lid test: both, unexpected or missing")
+	stats           = flag.Bool("stats", false, "print how many files and bytes were scanned, how fast, and how many lines matched each rule")
+	rewriteBaseline = flag.Bool("write-baseline", false, "record the current unexpected matches into the -baseline file")
+	ext             = flag.String("ext", "", "only scan files with these comma separated extensions, narrowing the configured includes")
+	tags            = flag.String("tags", "", "only run the rules with one of these comma separated tags, leaving out untagged rules")
+	fix             = flag.Bool("fix", false, "remove the unexpected lines matched by rules marked fixable, or replace what they match as their replace says, rewriting files in place")
+	fixDryRun       = flag.Bool("fix-dry-run", false, "print what -fix would change as a unified diff instead, exiting with -exit-code if anything")
+	contextLines    = flag.Int("context", 0, "print this many lines before and after unexpected matches in the text output")
+	listRules       = flag.Bool("list-rules", false, "only print the rules as parsed, and the paths they apply to")
+	audit           = flag.Bool("audit", false, "also report the expected files which don't exist, are listed twice, or are excluded")
+	explain         = flag.Bool("explain", false, "list the files each rule would scan, and how many each include pulls in, without scanning them")
+	strict          = flag.Bool("strict", false, "fail on the first file or directory which can't be read, instead of skipping it with a warning")
+	cacheFile       = flag.String("cache", "", "keep what each file matched in this file, so that the next run only scans the files which changed")
+	lidderignore    = flag.Bool("lidderignore", true, "skip the paths listed by the .lidderignore file at the root, if any")
+	exitCode        = flag.Int("exit-code", 2, "exit status when the lid test fails, from 1 to 255")
+	errorExitCode   = flag.Int("error-exit-code", 1, "exit status when lidder itself fails, from 1 to 255")
+	warnExitCode    = flag.Int("warn-exit-code", 0, "exit status when only rules of warning severity, below -fail-level, failed, up to 255, 0 leaving such runs successful")
+	requireScanned  = flag.Bool("require-scanned", false, "exit with -error-exit-code when the config applies to no file under the root, instead of only warning")
+	timeout         = flag.Duration("timeout", 0, "give up on a file once scanning it took this long, such as 10s, reporting it as timed out")
+	abs             = flag.Bool("abs", false, "print absolute paths instead of paths relative to the root, in every format but the diffs of -fix-dry-run")
+	initialize      = flag.Bool("init", false, "only write a starter config to "+initFilename+" in the current directory")
+	force           = flag.Bool("force", false, "let -init overwrite an existing "+initFilename)
+	quiet           = flag.Bool("quiet", false, "print nothing, only exit with -exit-code when the lid test fails; errors are still reported")
+)
+
+// byteSize is a flag value for a number of bytes, optionally suffixed with
+// k, m or g
+type byteSize int64
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(value string) error {
+	digits, multiplier := value, int64(1)
+	if n := len(value); n > 0 {
+		switch value[n-1] {
+		case 'k', 'K':
+			multiplier = 1 << 10
+		case 'm', 'M':
+			multiplier = 1 << 20
+		case 'g', 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier != 1 {
+			digits = value[:n-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size '%s'", value)
+	}
+	*b = byteSize(n * multiplier)
+	return nil
+}
+
+var maxFileSize = byteSize(10 << 20)
+
+func init() {
+	flag.Var(&maxFileSize, "max-file-size", "skip files larger than this, in bytes or with a k, m or g suffix; 0 means no limit")
+}
+
+// stringList is a flag value which may be repeated
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+var configs, includes, excludes stringList
+
+func init() {
+	flag.Var(&configs, "config", "config file, may be repeated or comma-separated to merge several, later rules replacing earlier ones with the same pattern")
+	flag.Var(&includes, "include", "regular expression of paths to scan on top of the config's includes, may be repeated; rules with their own includes keep them")
+	flag.Var(&excludes, "exclude", "regular expression of paths to skip on top of the config's excludes, may be repeated; excludes win over includes")
+}
+
+func usage() {
+	fmt.Println("usage: lidder [flags] [config.yaml] [path ...]")
+	fmt.Println("       lidder [flags] -config=config.yaml[,...] [path ...]")
+	fmt.Println("       lidder -init [-force]")
+	fmt.Println("  -- A config may be - to read it from stdin, or an http:// or https:// URL to fetch it")
+	fmt.Println("  -- Without a config, lidder.yaml or .lidder.yaml is looked for here, then up to the repository root")
+	fmt.Println("  -- The first argument is taken for a config when it ends in .yaml or .yml, is a URL, or no config is found")
+	fmt.Println("  -- If no path is specified, defaults to scanning all files from the -root directory recursively")
+	fmt.Println("  -- Otherwise only the given files, and the files under the given directories, are scanned")
+	fmt.Println("  -- If the path is - or -stdin is set, only the files listed on stdin are scanned")
+	fmt.Println("  -- If the path is a .tar, .tar.gz or .zip archive, the files within it are scanned")
+	fmt.Println("  -- A failed lid test exits with -exit-code, before unreadable files with -error-exit-code, before warnings with -warn-exit-code")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if *initialize {
+		if flag.NArg() != 0 || len(configs) != 0 {
+			oops(fmt.Errorf("-init takes no config or paths"))
+		}
+		if err := writeInitConfig(*force); err != nil {
+			oops(err)
+		}
+		if !*quiet {
+			fmt.Printf("ok\tstarter config written to %s\n", initFilename)
+		}
+		return
+	}
+
+	var configFiles []string
+	for _, value := range configs {
+		configFiles = append(configFiles, strings.Split(value, ",")...)
+	}
+	args := flag.Args()
+	if len(configFiles) == 0 {
+		configFiles, args = configFromArgs(args)
+	}
+	// the only path given, for stdin, an archive or a single file
+	file := ""
+	if len(args) == 1 {
+		file = args[0]
+	}
+
+	if *errorExitCode < 1 || *errorExitCode > 255 {
+		code := *errorExitCode
+		*errorExitCode = 1
+		oops(fmt.Errorf("-error-exit-code %d is out of the 1 to 255 range", code))
+	}
+	if *exitCode < 1 || *exitCode > 255 {
+		oops(fmt.Errorf("-exit-code %d is out of the 1 to 255 range", *exitCode))
+	}
+	if *warnExitCode < 0 || *warnExitCode > 255 {
+		oops(fmt.Errorf("-warn-exit-code %d is out of the 0 to 255 range", *warnExitCode))
+	}
+	switch *format {
+	case "text", "json", "jsonl", "sarif", "junit", "html", "tsv", "github", "files":
+	default:
+		oops(fmt.Errorf("unknown format '%s'", *format))
+	}
+	switch *color {
+	case "auto", "always", "never":
+	default:
+		oops(fmt.Errorf("unknown color mode '%s'", *color))
+	}
+	switch *progress {
+	case "auto", "always", "never":
+	default:
+		oops(fmt.Errorf("unknown progress mode '%s'", *progress))
+	}
+	switch *failOn {
+	case "both", "unexpected", "missing":
+	default:
+		oops(fmt.Errorf("unknown -fail-on '%s'", *failOn))
+	}
+	switch *failLevel {
+	case "error", "warning", "info":
+	default:
+		oops(fmt.Errorf("unknown -fail-level '%s'", *failLevel))
+	}
+	if *since != "" && (*stdin || len(args) != 0) {
+		oops(fmt.Errorf("-since can't be combined with -stdin or paths to scan"))
+	}
+	if len(args) > 1 {
+		for _, path := range args {
+			if path == "-" || lidder.IsArchive(path) {
+				oops(fmt.Errorf("%s must be the only path to scan", path))
+			}
+		}
+	}
+	if *stdin && len(args) != 0 {
+		oops(fmt.Errorf("-stdin can't be combined with paths to scan"))
+	}
+	if *rewriteBaseline && *baselineFile == "" {
+		oops(fmt.Errorf("-write-baseline requires -baseline"))
+	}
+
+	for _, filename := range configFiles {
+		if filename == "-" && (*stdin || file == "-") {
+			oops(fmt.Errorf("the config and the files to scan can't both be read from stdin"))
+		}
+	}
+	if *watch {
+		if *stdin || len(args) != 0 || *fix || *fixDryRun || *rewriteBaseline {
+			oops(fmt.Errorf("-watch can't be combined with -stdin, paths to scan, -fix, -fix-dry-run or -write-baseline"))
+		}
+		for _, filename := range configFiles {
+			if filename == "-" {
+				oops(fmt.Errorf("-watch needs a config which can be read again"))
+			}
+		}
+	}
+
+	defs, err := loadDefs(configFiles)
+	if err != nil {
+		oops(err)
+	}
+	w, err := openOut()
+	if err != nil {
+		oops(err)
+	}
+	if *listRules {
+		printRules(w, defs)
+		return
+	}
+	if *checkConfig {
+		audits, err := lidder.Unscannable(defs, *root)
+		if err != nil {
+			oops(err)
+		}
+		if len(audits) != 0 {
+			oops(excludedError(audits))
+		}
+		if !*quiet {
+			printOverlaps(lidder.Overlaps(defs))
+			fmt.Fprintln(w, "ok\tconfig is valid")
+		}
+		return
+	}
+	fi, err := os.Stat(*root)
+	if err != nil {
+		oops(err)
+	}
+	if !fi.IsDir() {
+		oops(fmt.Errorf("-root %s is not a directory", *root))
+	}
+	setOptions(defs)
+	if useProgress(*progress) {
+		defs.Options.Progress = os.Stderr
+	}
+
+	for _, name := range defs.UnsetVars() {
+		if *strictEnv {
+			oops(fmt.Errorf("environment variable %s is not set", name))
+		}
+		if *verbose {
+			fmt.Fprintf(os.Stderr, "warning: environment variable %s is not set\n", name)
+		}
+	}
+
+	if *explain {
+		scopes, err := lidder.Explain(defs, *root)
+		if err != nil {
+			oops(scanError(err))
+		}
+		counts := includeCounts(defs.Rules, scopes)
+		if *abs {
+			for _, scope := range scopes {
+				absolutePaths(scope)
+			}
+		}
+		printExplain(w, defs.Rules, scopes, counts)
+		return
+	}
+	if *watch {
+		err = watchRoot(configFiles)
+		if err != nil {
+			oops(err)
+		}
+		return
+	}
+
+	singleFileMode := false
+	if *since != "" {
+		defs.Options.Files, err = changedFiles(*root, *since)
+		if err != nil {
+			oops(err)
+		}
+	} else if *stdin || file == "-" {
+		defs.Options.Files, err = lidder.ReadFileList(os.Stdin)
+		if err != nil {
+			oops(err)
+		}
+	} else if lidder.IsArchive(file) {
+		defs.Options.Archive = file
+	} else if len(args) != 0 {
+		// a single file is reported on its own, as if it was all there is
+		path := file
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(*root, path)
+		}
+		if fi, err := os.Stat(path); file != "" && err == nil && fi.Mode().IsRegular() {
+			singleFileMode = true
+		}
+		defs.Options.Files = args
+	}
+
+	if *baselineFile != "" && !*rewriteBaseline {
+		defs.Options.Baseline, err = lidder.ReadBaseline(*baselineFile)
+		if err != nil {
+			oops(err)
+		}
+	}
+
+	// an interrupt stops the scan, what it found so far being reported, and
+	// a second one kills lidder as usual
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defs.Options.Interrupt = ctx
+	results, err := lidder.Run(defs, *root)
+	stop()
+	if err != nil {
+		oops(scanError(err))
+	}
+	// the baseline keeps the paths relative to the root, as the results it's
+	// applied to have them
+	relative := results
+	if *abs {
+		results = absoluteResults(results)
+	}
+	printWarnings(results)
+	// changing nothing the config applies to is fine with -since
+	if results.Stats.Checked == 0 && *since == "" && !results.Partial {
+		err := fmt.Errorf("no files were checked: the include and exclude patterns of the config match nothing under %s", *root)
+		if *requireScanned {
+			oops(err)
+		}
+		if !*quiet {
+			fmt.Fprintf(os.Stderr, "warning: %s\n", err)
+		}
+	}
+	testFailed := failed(results)
+
+	if *fixDryRun {
+		if !*quiet {
+			printDiffs(w, results.Diffs)
+		}
+		if len(results.Diffs) != 0 {
+			os.Exit(*exitCode)
+		}
+		return
+	}
+
+	if *rewriteBaseline {
+		if results.Partial {
+			oops(fmt.Errorf("not writing the baseline from an interrupted scan"))
+		}
+		err = lidder.WriteBaseline(*baselineFile, relative)
+		if err != nil {
+			oops(err)
+		}
+		if !*quiet {
+			fmt.Fprintf(w, "ok\tbaseline written to %s\n", *baselineFile)
+		}
+		return
+	}
+
+	if *quiet {
+		exit(testFailed, results)
+		return
+	}
+
+	printResults(w, results, singleFileMode, testFailed)
+	if *audit {
+		audits, err := lidder.Audit(defs, *root)
+		if err != nil {
+			oops(err)
+		}
+		if *abs {
+			for _, audit := range audits {
+				absolutePaths(audit.Dead)
+				absolutePaths(audit.Duplicates)
+				absolutePaths(audit.Excluded)
+			}
+		}
+		printAudit(output(w), audits)
+	}
+	exit(testFailed, results)
+}
+
+// loadDefs reads and merges the configs
+func loadDefs(configFiles []string) (*lidder.Defs, error) {
+	configs := make([]lidder.Config, len(configFiles))
+	for i, filename := range configFiles {
+		content, err := readConfig(filename)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load config %s: %s", filename, err)
+		}
+		configs[i].Content = content
+		// includes of stdin and remote configs are relative to the current
+		// directory
+		if filename != "-" && !strings.Contains(filename, "://") {
+			configs[i].Filename = filename
+		}
+	}
+	defs, err := lidder.ParseConfigs(configs...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %s", err)
+	}
+	if err := defs.AddPaths(includes, excludes); err != nil {
+		return nil, fmt.Errorf("invalid -include or -exclude: %s", err)
+	}
+	return defs, nil
+}
+
+// configFromArgs takes the config, when not given by -config, from the
+// arguments, or else finds the default one, returning the paths left. The
+// default one found in a parent directory is for the files under it, which
+// becomes the root unless -root is given.
+func configFromArgs(args []string) ([]string, []string) {
+	if len(args) > 0 && looksLikeConfig(args[0]) {
+		return args[:1], args[1:]
+	}
+	found, err := findConfig(".")
+	if err != nil {
+		oops(err)
+	}
+	if found != "" {
+		if !flagGiven("root") {
+			args, err = rootAt(filepath.Dir(found), args)
+			if err != nil {
+				oops(err)
+			}
+		}
+		return []string{found}, args
+	}
+	// as before, with configs named otherwise
+	if len(args) > 0 {
+		return args[:1], args[1:]
+	}
+	usage()
+	oops(fmt.Errorf("no config given, and no %s found here or up to the repository root; lidder -init writes one", strings.Join(defaultConfigs, " or ")))
+	return nil, nil
+}
+
+// flagGiven tells whether the flag called name is on the command line
+func flagGiven(name string) bool {
+	given := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			given = true
+		}
+	})
+	return given
+}
+
+// rootAt makes dir the root when it isn't the working directory, returning
+// args, the relative paths among them being made relative to dir
+func rootAt(dir string, args []string) ([]string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	if dir == wd {
+		return args, nil
+	}
+	*root = dir
+	rebased := make([]string, len(args))
+	for i, arg := range args {
+		rebased[i] = arg
+		if arg == "-" || filepath.IsAbs(arg) || lidder.IsArchive(arg) {
+			continue
+		}
+		rebased[i], err = filepath.Rel(dir, filepath.Join(wd, arg))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rebased, nil
+}
+
+// scanError tells errors met while scanning apart from the others
+func scanError(err error) error {
+	return fmt.Errorf("scan failed: %s", err)
+}
+
+// setOptions tells defs how to run as the flags say, except for what's
+// scanned
+func setOptions(defs *lidder.Defs) {
+	defs.Options = lidder.Options{
+		Jobs:           *jobs,
+		Gitignore:      *gitignore,
+		LidderIgnore:   *lidderignore,
+		AllMatches:     *allMatches,
+		IncludeBinary:  *includeBinary,
+		FollowSymlinks: *followSymlinks,
+		Hidden:         *hidden,
+		MaxFileSize:    int64(maxFileSize),
+		Verbose:        *verbose,
+		Context:        *contextLines,
+		Fix:            *fix || *fixDryRun,
+		DryRun:         *fixDryRun,
+		Strict:         *strict,
+		Cache:          *cacheFile,
+		Timeout:        *timeout,
+	}
+	if *ext != "" {
+		defs.Options.Extensions = strings.Split(*ext, ",")
+	}
+	if *tags != "" {
+		defs.Options.Tags = strings.Split(*tags, ",")
+	}
+}
+
+// output is where to write what comes along the results: stdout for the text
+// format, stderr otherwise to keep structured output parseable
+func output(w io.Writer) io.Writer {
+	if *format == "text" {
+		return w
+	}
+	return os.Stderr
+}
+
+// openOut is where the report goes: stdout, or the -out file, which is
+// truncated if it exists
+func openOut() (*os.File, error) {
+	if *out == "" {
+		return os.Stdout, nil
+	}
+	return os.Create(*out)
+}
+
+// failed tells whether the lid test failed, as -fail-on and -fail-level say
+func failed(results lidder.Results) bool {
+	for _, result := range results.Rules {
+		if result.Fails(*failOn) && result.Rule.IsAtLeast(*failLevel) {
+			return true
+		}
+	}
+	return false
+}
+
+// warned tells whether rules of warning severity failed, as -fail-on says,
+// which is only worth telling when the lid test didn't fail
+func warned(results lidder.Results) bool {
+	for _, result := range results.Rules {
+		if result.Fails(*failOn) && result.Rule.IsAtLeast("warning") {
+			return true
+		}
+	}
+	return false
+}
+
+// printResults reports results in the -format asked for
+func printResults(w io.Writer, results lidder.Results, singleFileMode, testFailed bool) {
+	var err error
+	switch *format {
+	case "json":
+		err = printJSON(w, results.Rules, !testFailed && !results.Partial)
+		if err != nil {
+			oops(err)
+		}
+	case "jsonl":
+		err = printJSONL(w, results.Rules)
+		if err != nil {
+			oops(err)
+		}
+	case "sarif":
+		err = printSARIF(w, results.Rules)
+		if err != nil {
+			oops(err)
+		}
+	case "junit":
+		err = printJUnit(w, results.Rules)
+		if err != nil {
+			oops(err)
+		}
+	case "html":
+		err = printHTML(w, results.Rules, results.Stats.Checked, !testFailed, results.Partial)
+		if err != nil {
+			oops(err)
+		}
+	case "tsv":
+		err = printTSV(w, results.Rules)
+		if err != nil {
+			oops(err)
+		}
+	case "files":
+		err = printFiles(w, results.Rules)
+		if err != nil {
+			oops(err)
+		}
+	case "github":
+		err = printGitHub(w, results.Rules, results.Stats.Checked, !testFailed, results.Partial)
+		if err != nil {
+			oops(err)
+		}
+	default:
+		printText(w, results.Rules, results.Stats.Checked, singleFileMode, !testFailed, results.Partial, *contextLines > 0, useColor(*color, w))
+	}
+
+	w = output(w)
+	if len(results.Fixed) != 0 || len(results.Replaced) != 0 {
+		printFixed(w, results.Fixed, results.Replaced)
+	}
+	if *stats {
+		printStats(w, results)
+	}
+}
+
+// printWarnings reports the files which couldn't be read, or were only
+// partly scanned, to stderr
+func printWarnings(results lidder.Results) {
+	for _, err := range results.Unreadable {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", err)
+	}
+	for _, filename := range results.TimedOut {
+		fmt.Fprintf(os.Stderr, "warning: timed out scanning '%s' after %s, only part of it was checked\n", filename, *timeout)
+	}
+	if results.Partial {
+		fmt.Fprintf(os.Stderr, "warning: interrupted after checking %s, the results are partial\n", files(results.Stats.Checked))
+	}
+}
+
+// exit tells how the run went: -exit-code when the lid test failed, or else
+// -error-exit-code when some files couldn't be read, or timed out, or the scan
+// was interrupted, and so weren't checked, or else -warn-exit-code, when set,
+// when warnings failed
+func exit(testFailed bool, results lidder.Results) {
+	if testFailed {
+		os.Exit(*exitCode)
+	}
+	if len(results.Unreadable) != 0 || len(results.TimedOut) != 0 || results.Partial {
+		os.Exit(*errorExitCode)
+	}
+	if *warnExitCode != 0 && warned(results) {
+		os.Exit(*warnExitCode)
+	}
+}
+
+// absolute is filename, relative to the root, as an absolute path for -abs
+func absolute(filename string) string {
+	if filepath.IsAbs(filename) {
+		return filename
+	}
+	path, err := filepath.Abs(filepath.Join(*root, filename))
+	if err != nil {
+		// only when the current directory is gone
+		return filename
+	}
+	return path
+}
+
+// absolutePaths makes every filename absolute in place
+func absolutePaths(filenames []string) {
+	for i, filename := range filenames {
+		filenames[i] = absolute(filename)
+	}
+}
+
+// absoluteCopy is filenames made absolute, leaving them as they are
+func absoluteCopy(filenames []string) []string {
+	if filenames == nil {
+		return nil
+	}
+	abs := append([]string(nil), filenames...)
+	absolutePaths(abs)
+	return abs
+}
+
+// absoluteKeys is m with its filenames made absolute
+func absoluteKeys(m map[string]int) map[string]int {
+	if m == nil {
+		return nil
+	}
+	abs := make(map[string]int, len(m))
+	for filename, n := range m {
+		abs[absolute(filename)] = n
+	}
+	return abs
+}
+
+// absoluteResults is results with their filenames made absolute, but for
+// those in diffs, which stay relative like any patch. results is left as is,
+// for the baseline to be written from.
+func absoluteResults(results lidder.Results) lidder.Results {
+	rules := make([]*lidder.RuleResult, len(results.Rules))
+	for i, result := range results.Rules {
+		abs := *result
+		abs.ShouldNotBeThere = absoluteCopy(result.ShouldNotBeThere)
+		abs.ShouldBeThere = absoluteCopy(result.ShouldBeThere)
+		abs.Matches = make(map[string][]lidder.Match, len(result.Matches))
+		for filename, m := range result.Matches {
+			m = append([]lidder.Match(nil), m...)
+			for i := range m {
+				if m[i].File != "" {
+					m[i].File = absolute(m[i].File)
+				}
+			}
+			abs.Matches[absolute(filename)] = m
+		}
+		abs.MatchCounts = absoluteKeys(result.MatchCounts)
+		rules[i] = &abs
+	}
+	results.Rules = rules
+	results.Fixed = absoluteKeys(results.Fixed)
+	results.Replaced = absoluteKeys(results.Replaced)
+	results.TimedOut = absoluteCopy(results.TimedOut)
+	return results
+}
+
+// how long fetching a config over HTTP may take
+const fetchTimeout = 30 * time.Second
+
+// readConfig reads a config from a file, from stdin when filename is -, or
+// from an http:// or https:// URL
+func readConfig(filename string) ([]byte, error) {
+	switch {
+	case filename == "-":
+		return ioutil.ReadAll(os.Stdin)
+	case strings.HasPrefix(filename, "http://"), strings.HasPrefix(filename, "https://"):
+		client := &http.Client{Timeout: fetchTimeout}
+		resp, err := client.Get(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s", resp.Status)
+		}
+		return ioutil.ReadAll(resp.Body)
+	}
+	return ioutil.ReadFile(filename)
+}
+
+// printRules shows what merging configs and expanding environment variables
+// made of them
+func printRules(w io.Writer, defs *lidder.Defs) {
+	include, exclude := defs.Paths()
+	fmt.Fprintf(w, "include: %s\n", strings.Join(include, ", "))
+	fmt.Fprintf(w, "exclude: %s\n", strings.Join(exclude, ", "))
+	for _, rule := range defs.Rules {
+		fmt.Fprintln(w)
+		if rule.Name != "" {
+			fmt.Fprintln(w, rule.Name)
+		}
+		fmt.Fprintf(w, "  pattern: %s\n", rule.Pattern)
+		fmt.Fprintf(w, "  expected: %d files\n", len(rule.Expected))
+		if len(rule.Tags) != 0 {
+			fmt.Fprintf(w, "  tags: %s\n", strings.Join(rule.Tags, ", "))
+		}
+		include, exclude := rule.Paths()
+		if rule.Include != nil {
+			fmt.Fprintf(w, "  include: %s\n", strings.Join(include, ", "))
+		}
+		if rule.Exclude != nil {
+			fmt.Fprintf(w, "  exclude: %s\n", strings.Join(exclude, ", "))
+		}
+		if rule.Shebang != "" {
+			fmt.Fprintf(w, "  shebang: %s\n", rule.Shebang)
+		}
+		if rule.Scope != "" {
+			fmt.Fprintf(w, "  scope: %s\n", rule.Scope)
+		}
+		if rule.Forbidden {
+			fmt.Fprintln(w, "  forbidden anywhere")
+		}
+	}
+}
+
+// printAudit lists the clutter lidder.Audit found in each rule's expected
+// files
+func printAudit(w io.Writer, audits []*lidder.RuleAudit) {
+	fmt.Fprintln(w, "\naudit:")
+	if len(audits) == 0 {
+		fmt.Fprintln(w, "  no dead, duplicate or excluded expected files")
+	}
+	for _, audit := range audits {
+		fmt.Fprintf(w, "  %s\n", audit.Rule.Title())
+		for _, filename := range audit.Dead {
+			fmt.Fprintf(w, "   - %s doesn't exist\n", filename)
+		}
+		for _, filename := range audit.Duplicates {
+			fmt.Fprintf(w, "   - %s is listed more than once\n", filename)
+		}
+		for _, filename := range audit.Excluded {
+			fmt.Fprintf(w, "   - %s is excluded from the rule, so never scanned\n", filename)
+		}
+	}
+}
+
+// printOverlaps warns of the rules which would report the same lines, to
+// stderr as the config is still valid
+func printOverlaps(overlaps []*lidder.RuleOverlap) {
+	for _, overlap := range overlaps {
+		var warning string
+		if overlap.Identical {
+			warning = fmt.Sprintf("rules '%s' and '%s' have the same pattern", overlap.Rule.Title(), overlap.Other.Title())
+		} else {
+			warning = fmt.Sprintf("every line rule '%s' matches, rule '%s' matches too", overlap.Rule.Title(), overlap.Other.Title())
+		}
+		if overlap.Conflicting {
+			warning += ", but they expect different files"
+		}
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+}
+
+// excludedError spells out, rule by rule, the expected files lidder would
+// never scan
+func excludedError(audits []*lidder.RuleAudit) error {
+	var b strings.Builder
+	b.WriteString("expected files are excluded, so never scanned:")
+	for _, audit := range audits {
+		for _, filename := range audit.Excluded {
+			fmt.Fprintf(&b, "\n  %s: %s", audit.Rule.Title(), filename)
+		}
+	}
+	return errors.New(b.String())
+}
+
+// printExplain lays out what lidder.Explain found
+func printExplain(w io.Writer, rules []*lidder.Rule, scopes [][]string, counts [][]int) {
+	for i, rule := range rules {
+		fmt.Fprintf(w, "%s: %d files\n", rule.Title(), len(scopes[i]))
+		include, _ := rule.Paths()
+		for j, expr := range include {
+			fmt.Fprintf(w, "   include %s: %s", expr, files(counts[i][j]))
+			if counts[i][j] == 0 {
+				fmt.Fprint(w, ", matches nothing the others don't")
+			}
+			fmt.Fprintln(w)
+		}
+		for _, filename := range scopes[i] {
+			fmt.Fprintf(w, "   - %s\n", filename)
+		}
+	}
+}
+
+// includeCounts tells, for each rule, how many of the files in its scope
+// each of its includes pulled in, crediting the first which matches
+func includeCounts(rules []*lidder.Rule, scopes [][]string) [][]int {
+	counts := make([][]int, len(rules))
+	for i, rule := range rules {
+		include, _ := rule.Paths()
+		counts[i] = make([]int, len(include))
+		for _, filename := range scopes[i] {
+			if j := rule.IncludedBy(filename); j >= 0 {
+				counts[i][j]++
+			}
+		}
+	}
+	return counts
+}
+
+// printFixed reports what -fix removed and replaced
+func printFixed(w io.Writer, fixed, replaced map[string]int) {
+	filenames := make([]string, 0, len(fixed)+len(replaced))
+	for filename := range fixed {
+		filenames = append(filenames, filename)
+	}
+	for filename := range replaced {
+		if _, ok := fixed[filename]; !ok {
+			filenames = append(filenames, filename)
+		}
+	}
+	sort.Strings(filenames)
+
+	fmt.Fprintln(w, "fixed:")
+	for _, filename := range filenames {
+		var changes []string
+		if n := fixed[filename]; n != 0 {
+			changes = append(changes, fmt.Sprintf("%d lines removed", n))
+		}
+		if n := replaced[filename]; n != 0 {
+			changes = append(changes, fmt.Sprintf("%d substitutions", n))
+		}
+		fmt.Fprintf(w, "   - %s: %s\n", filename, strings.Join(changes, ", "))
+	}
+}
+
+// printDiffs writes the diffs of -fix-dry-run, file by file
+func printDiffs(w io.Writer, diffs map[string]string) {
+	filenames := make([]string, 0, len(diffs))
+	for filename := range diffs {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	for _, filename := range filenames {
+		fmt.Fprint(w, diffs[filename])
+	}
+}
+
+// printStats is informational only and doesn't affect the lid test
+func printStats(w io.Writer, results lidder.Results) {
+	total := 0
+	counts := make([]int, len(results.Rules))
+	for i, result := range results.Rules {
+		for _, count := range result.MatchCounts {
+			counts[i] += count
+		}
+		total += counts[i]
+	}
+
+	fmt.Fprintln(w, "\nstats:")
+	fmt.Fprintf(w, "  files checked: %d\n", results.Stats.Checked)
+	fmt.Fprintf(w, "  files scanned: %d\n", results.Stats.Files)
+	if results.Stats.Cached != 0 {
+		fmt.Fprintf(w, "  files unchanged since cached: %d\n", results.Stats.Cached)
+	}
+	fmt.Fprintf(w, "  bytes read: %d\n", results.Stats.Bytes)
+	fmt.Fprintf(w, "  duration: %s\n", results.Stats.Duration.Round(time.Millisecond))
+	if seconds := results.Stats.Duration.Seconds(); seconds > 0 {
+		fmt.Fprintf(w, "  files per second: %.0f\n", float64(results.Stats.Files)/seconds)
+	}
+	fmt.Fprintf(w, "  total matches: %d\n", total)
+	for i, result := range results.Rules {
+		fmt.Fprintf(w, "  %s: %d matches in %d files\n", result.Rule.Pattern, counts[i], len(result.MatchCounts))
+	}
+}
+
+// ANSI escape sequences used to color the text output
+const (
+	red    = "\x1b[31m"
+	green  = "\x1b[32m"
+	yellow = "\x1b[33m"
+	reset  = "\x1b[0m"
+)
+
+// useColor resolves the -color mode, auto meaning only when the report goes to
+// a terminal
+func useColor(mode string, w io.Writer) bool {
+	switch mode {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := file.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// useProgress resolves the -progress mode, auto meaning only when stderr is a
+// terminal which -v or -quiet don't otherwise use
+func useProgress(mode string) bool {
+	switch mode {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	if *verbose || *quiet {
+		return false
+	}
+	fi, err := os.Stderr.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func printText(w io.Writer, results []*lidder.RuleResult, checked int, singleFileMode, ok, partial, showContext, color bool) {
+	paint := func(code, s string) string {
+		if !color {
+			return s
+		}
+		return code + s + reset
+	}
+
+	for _, result := range results {
+		if !result.Failed() {
+			continue
+		}
+		if singleFileMode {
+			if len(result.ShouldNotBeThere) != 0 && result.Rule.Forbidden {
+				fmt.Fprintln(w, paint(red, fmt.Sprintf("FORBIDDEN pattern '%s' found%s", result.Rule.Title(), severityNote(result.Rule))))
+			} else if len(result.ShouldNotBeThere) != 0 {
+				fmt.Fprintln(w, paint(red, fmt.Sprintf("Lidded pattern '%s' found%s", result.Rule.Title(), severityNote(result.Rule))))
+			} else if len(result.ShouldBeThere) != 0 { // mutually exclusive for a single file
+				fmt.Fprintln(w, paint(yellow, fmt.Sprintf("Lidded pattern '%s' expected but not found%s", result.Rule.Title(), severityNote(result.Rule))))
+			}
+			if result.Rule.Message != "" {
+				fmt.Fprintf(w, "  %s\n", result.Rule.Message)
+			}
+		} else {
+			fmt.Fprintln(w, result.Rule.Title()+severityNote(result.Rule))
+			if result.Rule.Message != "" {
+				fmt.Fprintf(w, "  %s\n", result.Rule.Message)
+			}
+			if len(result.ShouldNotBeThere) != 0 {
+				fmt.Fprintf(w, "  %s:\n", unexpectedHeading(result.Rule))
+				for _, s := range result.ShouldNotBeThere {
+					for _, m := range result.Matches[s] {
+						fmt.Fprintf(w, "   - %s\n", paint(red, location(s, m)))
+						if showContext && m.Line > 0 {
+							printContext(w, m)
+						}
+					}
+				}
+			}
+			if len(result.ShouldBeThere) != 0 {
+				if result.Rule.RequireAll {
+					fmt.Fprintln(w, "  expected to find but missing from:")
+				} else {
+					fmt.Fprintln(w, "  expected exceptions which were missing:")
+				}
+				for _, s := range result.ShouldBeThere {
+					fmt.Fprintf(w, "   - %s\n", paint(yellow, s))
+				}
+			}
+			if note := fileCountNote(result); note != "" {
+				fmt.Fprintf(w, "  %s\n", paint(yellow, note))
+			}
+		}
+	}
+
+	if !ok {
+		if !singleFileMode {
+			failed, unexpected, missing := 0, 0, 0
+			for _, result := range results {
+				if result.Failed() {
+					failed++
+				}
+				unexpected += len(result.ShouldNotBeThere)
+				missing += len(result.ShouldBeThere)
+			}
+			fmt.Fprintf(w, "\n%d of %d rules failed across %s: %d unexpected matches, %d missing expected exceptions\n",
+				failed, len(results), files(checked), unexpected, missing)
+		}
+		fmt.Fprint(w, "\nlid test failed. sorry.\n")
+	}
+	// never ok, as the files left unscanned may well fail the lid test
+	if partial {
+		fmt.Fprintf(w, "\ninterrupted after checking %s, these results are partial.\n", files(checked))
+	}
+	if !ok || partial {
+		return
+	}
+
+	fmt.Fprintln(w, paint(green, fmt.Sprintf("ok\tlid on all the things across %s, nothing to see here.", files(checked))))
+}
+
+// files is a count of files, in words
+func files(n int) string {
+	if n == 1 {
+		return "1 file"
+	}
+	return fmt.Sprintf("%d files", n)
+}
+
+// unexpectedHeading introduces the unexpected matches of rule, those of a
+// forbidden rule being called out as such
+func unexpectedHeading(rule *lidder.Rule) string {
+	if rule.Forbidden {
+		return "FORBIDDEN anywhere, yet found in"
+	}
+	return "didn't expect to find"
+}
+
+// fileCountNote tells how the number of files the pattern was found in falls
+// outside of the rule's min_files to max_files range, if it does
+func fileCountNote(result *lidder.RuleResult) string {
+	switch {
+	case result.Shortfall != 0:
+		return fmt.Sprintf("found in %s, %d short of min_files %d",
+			files(result.Rule.MinFiles-result.Shortfall), result.Shortfall, result.Rule.MinFiles)
+	case result.Excess != 0:
+		return fmt.Sprintf("found in %s, %d over max_files %d",
+			files(result.Rule.MaxFiles+result.Excess), result.Excess, result.Rule.MaxFiles)
+	}
+	return ""
+}
+
+// severityNote tells rules which aren't errors apart in the text output
+func severityNote(rule *lidder.Rule) string {
+	if rule.IsAtLeast("error") {
+		return ""
+	}
+	return fmt.Sprintf(" [%s]", rule.Severity)
+}
+
+// location points at a match, path rules' matching the whole file
+func location(filename string, m lidder.Match) string {
+	filename = matchFile(filename, m)
+	if m.Line == 0 {
+		return filename
+	}
+	return fmt.Sprintf("%s:%d", filename, m.Line)
+}
+
+// matchFile is the file m was found in, among those of the directory when
+// filename is one, for rules of dir scope
+func matchFile(filename string, m lidder.Match) string {
+	if m.File != "" {
+		return m.File
+	}
+	return filename
+}
+
+// printContext lays out a match among its surrounding lines as grep -n -C
+// does, the matching line's number being followed by ':' and the others' by '-'
+func printContext(w io.Writer, m lidder.Match) {
+	first := m.Line - len(m.Before)
+	for i, line := range m.Before {
+		fmt.Fprintf(w, "     %d-%s\n", first+i, line)
+	}
+	fmt.Fprintf(w, "     %d:%s\n", m.Line, m.Text)
+	for i, line := range m.After {
+		fmt.Fprintf(w, "     %d-%s\n", m.Line+1+i, line)
+	}
+}
+
+type jsonRule struct {
+	Name       string   `json:"name"`
+	Message    string   `json:"message,omitempty"`
+	Pattern    string   `json:"pattern"`
+	Severity   string   `json:"severity"`
+	Unexpected []string `json:"unexpected"`
+	Missing    []string `json:"missing"`
+	// files under min_files, or over max_files
+	Shortfall int `json:"shortfall,omitempty"`
+	Excess    int `json:"excess,omitempty"`
+}
+
+type jsonReport struct {
+	OK    bool        `json:"ok"`
+	Rules []*jsonRule `json:"rules"`
+}
+
+// printJSON writes one entry per rule, failing or not, so consumers can rely
+// on the shape of the document
+func printJSON(w io.Writer, results []*lidder.RuleResult, ok bool) error {
+	report := jsonReport{OK: ok, Rules: make([]*jsonRule, len(results))}
+	for i, result := range results {
+		name := result.Rule.Name
+		if name == "" {
+			name = result.Rule.Pattern
+		}
+		report.Rules[i] = &jsonRule{
+			Name:       name,
+			Message:    result.Rule.Message,
+			Pattern:    result.Rule.Pattern,
+			Severity:   result.Rule.SeverityOrDefault(),
+			Unexpected: result.ShouldNotBeThere,
+			Missing:    result.ShouldBeThere,
+			Shortfall:  result.Shortfall,
+			Excess:     result.Excess,
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// oops reports err and exits with -error-exit-code
+func oops(err error) {
+	fmt.Fprintf(os.Stderr, "lidder: %s\n", err)
+	os.Exit(*errorExitCode)
+}
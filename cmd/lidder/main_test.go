@@ -0,0 +1,269 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/helloeave/lidder"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// update rewrites the golden files of the formatters instead of comparing
+// their output to them
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+type Zuite struct {
+	suite.Suite
+}
+
+func (s *Zuite) TestByteSize() {
+	for value, expected := range map[string]int64{"0": 0, "123": 123, "2k": 2048, "10M": 10 << 20, "1g": 1 << 30} {
+		var b byteSize
+		require.NoError(s.T(), b.Set(value))
+		require.Equal(s.T(), expected, int64(b))
+	}
+	for _, value := range []string{"", "k", "-1", "12x", "1.5m"} {
+		var b byteSize
+		require.Error(s.T(), b.Set(value))
+	}
+}
+
+func (s *Zuite) TestInitConfig() {
+	defs, err := lidder.Parse([]byte(initConfig))
+	require.NoError(s.T(), err)
+	require.Len(s.T(), defs.Rules, 2)
+	require.Equal(s.T(), "no exiting outside of main", defs.Rules[0].Name)
+}
+
+func (s *Zuite) TestLooksLikeConfig() {
+	for _, arg := range []string{"lidder.yaml", "conf/x.yml", "-", "https://example.com/lidder"} {
+		require.True(s.T(), looksLikeConfig(arg), arg)
+	}
+	for _, arg := range []string{"main.go", ".", "src", "out.tar.gz"} {
+		require.False(s.T(), looksLikeConfig(arg), arg)
+	}
+}
+
+func (s *Zuite) TestFindConfig() {
+	root, err := ioutil.TempDir("", "lidder")
+	require.NoError(s.T(), err)
+	defer os.RemoveAll(root)
+	root, err = filepath.EvalSymlinks(root)
+	require.NoError(s.T(), err)
+	write := func(name string) {
+		require.NoError(s.T(), os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0755))
+		require.NoError(s.T(), ioutil.WriteFile(filepath.Join(root, name), nil, 0644))
+	}
+	write("repo/.git/HEAD")
+	write("repo/sub/dir/x.go")
+	write("lidder.yaml")
+
+	// not beyond the repository
+	found, err := findConfig(filepath.Join(root, "repo/sub/dir"))
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), "", found)
+
+	write("repo/.lidder.yaml")
+	found, err = findConfig(filepath.Join(root, "repo/sub/dir"))
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), filepath.Join(root, "repo/.lidder.yaml"), found)
+
+	write("repo/sub/lidder.yaml")
+	found, err = findConfig(filepath.Join(root, "repo/sub"))
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), filepath.Join(root, "repo/sub/lidder.yaml"), found)
+}
+
+func (s *Zuite) TestConfigFromParent() {
+	repo, err := ioutil.TempDir("", "lidder")
+	require.NoError(s.T(), err)
+	defer os.RemoveAll(repo)
+	repo, err = filepath.EvalSymlinks(repo)
+	require.NoError(s.T(), err)
+	for name, content := range map[string]string{
+		".git/HEAD":   "",
+		"lidder.yaml": "include: [\\.go$]\nrules:\n  - pattern: panic\n    expected: [sub/a.go]\n",
+		"sub/a.go":    "panic(1)\n",
+	} {
+		require.NoError(s.T(), os.MkdirAll(filepath.Dir(filepath.Join(repo, name)), 0755))
+		require.NoError(s.T(), ioutil.WriteFile(filepath.Join(repo, name), []byte(content), 0644))
+	}
+	wd, err := os.Getwd()
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), os.Chdir(filepath.Join(repo, "sub")))
+	defer os.Chdir(wd)
+	defer func(previous string) { *root = previous }(*root)
+
+	// run from sub, the files are still those of the repository, and the
+	// paths given relative to sub
+	configFiles, args := configFromArgs([]string{"a.go"})
+	require.Equal(s.T(), []string{filepath.Join(repo, "lidder.yaml")}, configFiles)
+	require.Equal(s.T(), repo, *root)
+	require.Equal(s.T(), []string{"sub/a.go"}, args)
+
+	defs, err := loadDefs(configFiles)
+	require.NoError(s.T(), err)
+	results, err := lidder.Run(defs, *root)
+	require.NoError(s.T(), err)
+	require.False(s.T(), failed(results))
+	require.Equal(s.T(), 1, results.Stats.Checked)
+}
+
+func (s *Zuite) TestAbsoluteBaseline() {
+	dir, err := ioutil.TempDir("", "lidder")
+	require.NoError(s.T(), err)
+	defer os.RemoveAll(dir)
+	require.NoError(s.T(), os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+	require.NoError(s.T(), ioutil.WriteFile(filepath.Join(dir, "sub/b.go"), []byte("panic(1)\n"), 0644))
+	defer func(previous string) { *root = previous }(*root)
+	*root = dir
+
+	run := func(baseline *lidder.Baseline) lidder.Results {
+		defs, err := lidder.Parse([]byte("include: [\\.go$]\nrules:\n  - pattern: panic\n"))
+		require.NoError(s.T(), err)
+		defs.Options.Baseline = baseline
+		results, err := lidder.Run(defs, dir)
+		require.NoError(s.T(), err)
+		return results
+	}
+
+	// -abs -write-baseline
+	results := run(nil)
+	abs := absoluteResults(results)
+	require.Equal(s.T(), []string{filepath.Join(dir, "sub/b.go")}, abs.Rules[0].ShouldNotBeThere)
+	require.Contains(s.T(), abs.Rules[0].Matches, filepath.Join(dir, "sub/b.go"))
+	baselineFile := filepath.Join(dir, "baseline.yaml")
+	require.NoError(s.T(), lidder.WriteBaseline(baselineFile, results))
+
+	baseline, err := lidder.ReadBaseline(baselineFile)
+	require.NoError(s.T(), err)
+	require.False(s.T(), failed(run(baseline)))
+}
+
+func (s *Zuite) TestPrintText() {
+	defs, err := lidder.Parse([]byte("include: [\\.go$]\nrules:\n  - pattern: panic\n    forbidden: true\n"))
+	require.NoError(s.T(), err)
+	results, err := lidder.MatchStrings(defs, map[string]string{"a.go": "panic(1)\n"})
+	require.NoError(s.T(), err)
+
+	var b bytes.Buffer
+	printText(&b, results.Rules, results.Stats.Checked, false, false, false, false, useColor("auto", &b))
+	require.Equal(s.T(), `panic
+  FORBIDDEN anywhere, yet found in:
+   - a.go:1
+
+1 of 1 rules failed across 1 file: 1 unexpected matches, 0 missing expected exceptions
+
+lid test failed. sorry.
+`, b.String())
+}
+
+func (s *Zuite) TestPrintFiles() {
+	defs, err := lidder.Parse([]byte(`
+include: [\.go$]
+rules:
+  - pattern: panic
+    expected: [c.go]
+  - pattern: TODO
+`))
+	require.NoError(s.T(), err)
+	results, err := lidder.MatchStrings(defs, map[string]string{
+		"b.go": "panic(1) // TODO\n",
+		"a.go": "// TODO\n",
+		"c.go": "panic(2)\n",
+		"d.go": "package d\n",
+	})
+	require.NoError(s.T(), err)
+
+	var b bytes.Buffer
+	require.NoError(s.T(), printFiles(&b, results.Rules))
+	require.Equal(s.T(), "a.go\nb.go\n", b.String())
+}
+
+func (s *Zuite) TestSARIFRuleIDs() {
+	defs, err := lidder.Parse([]byte("include: [\\.go$]\nrules:\n  - pattern: panic\n  - pattern: panic\n    severity: warning\n"))
+	require.NoError(s.T(), err)
+	results, err := lidder.MatchStrings(defs, map[string]string{"a.go": "panic(1)\n"})
+	require.NoError(s.T(), err)
+
+	var b bytes.Buffer
+	require.NoError(s.T(), printSARIF(&b, results.Rules))
+	var log sarifLog
+	require.NoError(s.T(), json.Unmarshal(b.Bytes(), &log))
+	var ids []string
+	for _, rule := range log.Runs[0].Tool.Driver.Rules {
+		ids = append(ids, rule.ID)
+	}
+	require.Equal(s.T(), []string{"panic", "missing-exception/panic", "panic/2", "missing-exception/panic/2"}, ids)
+	for _, result := range log.Runs[0].Results {
+		require.Equal(s.T(), ids[result.RuleIndex], result.RuleID)
+	}
+	require.Len(s.T(), log.Runs[0].Results, 2)
+}
+
+// awkwardResults is a failed run whose path and matching line hold the
+// characters the formatters must escape
+func (s *Zuite) awkwardResults() []*lidder.RuleResult {
+	defs, err := lidder.Parse([]byte(`
+include: [\.go$]
+rules:
+  - name: 'odd, one: out'
+    pattern: 50%, or
+    message: "no 100%,\nor: else"
+    expected: [gone.go]
+`))
+	require.NoError(s.T(), err)
+	filename := "dir:x/50%,\nodd.go"
+	results, err := lidder.MatchStrings(defs, map[string]string{filename: "x := 50%, or:\n"})
+	require.NoError(s.T(), err)
+	// a multiline match, which spans lines
+	results.Rules[0].Matches[filename][0].Text = "x := 50%, or:\nmore"
+	return results.Rules
+}
+
+func (s *Zuite) TestFormatters() {
+	results := s.awkwardResults()
+	for name, print := range map[string]func(io.Writer) error{
+		"github.txt":  func(w io.Writer) error { return printGitHub(w, results, 1, false, false) },
+		"sarif.json":  func(w io.Writer) error { return printSARIF(w, results) },
+		"junit.xml":   func(w io.Writer) error { return printJUnit(w, results) },
+		"tsv.tsv":     func(w io.Writer) error { return printTSV(w, results) },
+		"jsonl.jsonl": func(w io.Writer) error { return printJSONL(w, results) },
+		"html.html":   func(w io.Writer) error { return printHTML(w, results, 1, false, false) },
+	} {
+		var b bytes.Buffer
+		require.NoError(s.T(), print(&b), name)
+		golden := filepath.Join("testdata", "golden", name)
+		if *update {
+			require.NoError(s.T(), os.MkdirAll(filepath.Dir(golden), 0755))
+			require.NoError(s.T(), ioutil.WriteFile(golden, b.Bytes(), 0644))
+			continue
+		}
+		expected, err := ioutil.ReadFile(golden)
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), string(expected), b.String(), name)
+	}
+}
+
+func TestRunAllTheTests(t *testing.T) {
+	suite.Run(t, new(Zuite))
+}
@@ -0,0 +1,175 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/helloeave/lidder"
+)
+
+// the subset of SARIF 2.1.0 needed to report lidder's results, see
+// https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+
+type sarifLog struct {
+	Version string      `json:"version"`
+	Schema  string      `json:"$schema"`
+	Runs    []*sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool      `json:"tool"`
+	Results []*sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string                 `json:"name"`
+	InformationURI string                 `json:"informationUri"`
+	Rules          []*sarifRuleDescriptor `json:"rules"`
+}
+
+type sarifRuleDescriptor struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifResult struct {
+	RuleID    string           `json:"ruleId"`
+	RuleIndex int              `json:"ruleIndex"`
+	Level     string           `json:"level"`
+	Message   sarifMessage     `json:"message"`
+	Locations []*sarifLocation `json:"locations"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+// sarifLevels map rule severities to SARIF levels
+var sarifLevels = map[string]string{"error": "error", "warning": "warning", "info": "note"}
+
+func sarifLocationOf(filename string, line int) []*sarifLocation {
+	uri := filepath.ToSlash(filename)
+	// absolute paths, with -abs, are file URIs
+	if filepath.IsAbs(filename) {
+		if !strings.HasPrefix(uri, "/") {
+			uri = "/" + uri
+		}
+		uri = (&url.URL{Scheme: "file", Path: uri}).String()
+	}
+	location := &sarifLocation{sarifPhysicalLocation{
+		ArtifactLocation: sarifArtifactLocation{uri},
+	}}
+	if line > 0 {
+		location.PhysicalLocation.Region = &sarifRegion{line}
+	}
+	return []*sarifLocation{location}
+}
+
+// sarifRuleID is the id of rule, its name or else its pattern, suffixed when
+// one of the rules before it, which used records, has it already
+func sarifRuleID(rule *lidder.Rule, used map[string]bool) string {
+	id := rule.Name
+	if id == "" {
+		id = rule.Pattern
+	}
+	unique := id
+	for n := 2; used[unique]; n++ {
+		unique = fmt.Sprintf("%s/%d", id, n)
+	}
+	used[unique] = true
+	return unique
+}
+
+// printSARIF reports every rule under two ids: its name, or else its pattern,
+// for unexpected matches, and a derived one for expected exceptions which were
+// missing, so that code scanning can triage them separately. The ids stay the
+// same from one run to the next, but for rules sharing one, which the later
+// ones get a /2, /3... suffix to.
+func printSARIF(w io.Writer, results []*lidder.RuleResult) error {
+	run := &sarifRun{
+		Tool: sarifTool{sarifDriver{
+			Name:           "lidder",
+			InformationURI: "https://github.com/helloeave/lidder",
+		}},
+		Results: make([]*sarifResult, 0),
+	}
+
+	used := make(map[string]bool, len(results))
+	for _, result := range results {
+		pattern := result.Rule.Pattern
+		unexpectedID := sarifRuleID(result.Rule, used)
+		missingID := "missing-exception/" + unexpectedID
+		unexpectedIndex := len(run.Tool.Driver.Rules)
+		missingIndex := unexpectedIndex + 1
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules,
+			&sarifRuleDescriptor{unexpectedID, sarifMessage{fmt.Sprintf("Lidded pattern '%s'", result.Rule.Title())}},
+			&sarifRuleDescriptor{missingID, sarifMessage{fmt.Sprintf("Expected exception for lidded pattern '%s'", result.Rule.Title())}},
+		)
+
+		for _, filename := range result.ShouldNotBeThere {
+			for _, m := range result.Matches[filename] {
+				run.Results = append(run.Results, &sarifResult{
+					RuleID:    unexpectedID,
+					RuleIndex: unexpectedIndex,
+					Level:     sarifLevels[result.Rule.SeverityOrDefault()],
+					Message:   sarifMessage{fmt.Sprintf("Lidded pattern '%s' found", pattern)},
+					Locations: sarifLocationOf(matchFile(filename, m), m.Line),
+				})
+			}
+		}
+		for _, filename := range result.ShouldBeThere {
+			run.Results = append(run.Results, &sarifResult{
+				RuleID:    missingID,
+				RuleIndex: missingIndex,
+				Level:     sarifLevels[result.Rule.SeverityOrDefault()],
+				Message:   sarifMessage{fmt.Sprintf("Lidded pattern '%s' expected but not found", pattern)},
+				Locations: sarifLocationOf(filename, 0),
+			})
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []*sarifRun{run},
+	})
+}
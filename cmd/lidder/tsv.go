@@ -0,0 +1,50 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/helloeave/lidder"
+)
+
+// tsvColumns are the columns printTSV writes, which are kept in this order so
+// that its output can be tracked over time:
+//   - pattern: the rule's pattern
+//   - expected_count: how many entries, files or globs, Expected lists
+//   - actual_count: how many files the pattern was found in
+//   - unexpected: how many of those weren't expected
+//   - missing: how many expected entries the pattern wasn't found in
+var tsvColumns = []string{"pattern", "expected_count", "actual_count", "unexpected", "missing"}
+
+// tsvEscaper keeps a pattern within its field, writing its control characters
+// as the escapes which match them, so that it means the same
+var tsvEscaper = strings.NewReplacer("\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// printTSV reports one line per rule, after a header naming the tsvColumns
+func printTSV(out io.Writer, results []*lidder.RuleResult) error {
+	w := bufio.NewWriter(out)
+	fmt.Fprintln(w, strings.Join(tsvColumns, "\t"))
+	for _, result := range results {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\n",
+			tsvEscaper.Replace(result.Rule.Pattern),
+			len(result.Rule.Expected),
+			len(result.MatchCounts),
+			len(result.ShouldNotBeThere),
+			len(result.ShouldBeThere))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,169 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/helloeave/lidder"
+)
+
+// how long files must stay untouched before scanning again, so that a burst
+// of changes such as a checkout only scans once
+const watchDebounce = 200 * time.Millisecond
+
+// clears the terminal and moves the cursor to its top left
+const clearScreen = "\x1b[H\x1b[2J"
+
+// watchRoot scans the root, then again whenever something changes under it
+// or in the directories of the configs, which are read again each time. It
+// only returns when watching fails.
+func watchRoot(configFiles []string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	err = watchDirs(watcher, *root)
+	if err != nil {
+		return err
+	}
+	for _, filename := range configFiles {
+		if !strings.Contains(filename, "://") {
+			err = watcher.Add(filepath.Dir(filename))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// the cache and the -out report are written by every scan, which mustn't
+	// trigger another; -write-baseline isn't allowed with -watch
+	ignored := make(map[string]bool)
+	for _, filename := range []string{*cacheFile, *out} {
+		if filename != "" {
+			if path, err := filepath.Abs(filename); err == nil {
+				ignored[path] = true
+			}
+		}
+	}
+
+	for {
+		scanOnce(configFiles)
+		fmt.Fprintln(os.Stderr, "\nwatching for changes, interrupt to stop")
+
+		changed := false
+		var settle <-chan time.Time
+		for !changed || settle != nil {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return nil
+				}
+				if path, _ := filepath.Abs(event.Name); ignored[path] {
+					continue
+				}
+				if event.Op&fsnotify.Create != 0 {
+					if fi, err := os.Stat(event.Name); err == nil && fi.IsDir() {
+						// errors are those of a directory gone already
+						_ = watchDirs(watcher, event.Name)
+					}
+				}
+				changed = true
+				settle = time.After(watchDebounce)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return nil
+				}
+				return err
+			case <-settle:
+				settle = nil
+			}
+		}
+	}
+}
+
+// watchDirs watches dirname and the directories below it, but for .git ones
+func watchDirs(watcher *fsnotify.Watcher, dirname string) error {
+	return filepath.WalkDir(dirname, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if entry.Name() == ".git" {
+			return fs.SkipDir
+		}
+		return watcher.Add(path)
+	})
+}
+
+// scanOnce reads the configs again and reports how the root fares, errors
+// being reported too rather than ending the watch. The -out file is written
+// anew each time.
+func scanOnce(configFiles []string) {
+	results, err := scan(configFiles)
+	if *out == "" {
+		fmt.Print(clearScreen)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lidder: %s\n", err)
+		return
+	}
+	printWarnings(results)
+	w, err := openOut()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lidder: %s\n", err)
+		return
+	}
+	printResults(w, results, false, failed(results))
+	if w != os.Stdout {
+		w.Close()
+	}
+}
+
+func scan(configFiles []string) (lidder.Results, error) {
+	defs, err := loadDefs(configFiles)
+	if err != nil {
+		return lidder.Results{}, err
+	}
+	setOptions(defs)
+	if *since != "" {
+		defs.Options.Files, err = changedFiles(*root, *since)
+		if err != nil {
+			return lidder.Results{}, err
+		}
+	}
+	if *baselineFile != "" {
+		defs.Options.Baseline, err = lidder.ReadBaseline(*baselineFile)
+		if err != nil {
+			return lidder.Results{}, err
+		}
+	}
+	results, err := lidder.Run(defs, *root)
+	if err != nil {
+		return lidder.Results{}, scanError(err)
+	}
+	if *abs {
+		results = absoluteResults(results)
+	}
+	return results, nil
+}
@@ -0,0 +1,106 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lidder
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ParseErrorKind tells what's wrong with a config
+type ParseErrorKind string
+
+const (
+	// the config isn't YAML, or doesn't fit the schema, as with an unknown
+	// field or a string where a list is expected
+	InvalidYAML ParseErrorKind = "yaml"
+	// a pattern, path or expected glob doesn't compile
+	InvalidRegexp ParseErrorKind = "regexp"
+	// the config lacks something it needs, such as rules
+	MissingField ParseErrorKind = "missing"
+	// a field has an unknown value, or one which doesn't go with another
+	InvalidValue ParseErrorKind = "value"
+	// a file the config refers to, through include_configs or pattern_file,
+	// can't be read
+	UnreadableFile ParseErrorKind = "file"
+)
+
+// ParseError is what Parse and its variants return when a config is invalid
+type ParseError struct {
+	Kind ParseErrorKind
+	// the config the error is in, when several are parsed together or for
+	// one included by another: its filename, or its position as in
+	// "config 2", each including config coming first as in "a.yml: b.yml"
+	Source string
+	// the index of the rule the error is in, or -1 for the other fields
+	Rule int
+	// the field the error is in, if known, such as "pattern" or "include[2]"
+	Field string
+	// the pattern which doesn't compile, with InvalidRegexp
+	Pattern string
+	Err     error
+}
+
+func (e *ParseError) Error() string {
+	var b strings.Builder
+	if e.Source != "" {
+		b.WriteString(e.Source + ": ")
+	}
+	switch {
+	case e.Rule >= 0 && e.Field != "":
+		fmt.Fprintf(&b, "rules[%d].%s: ", e.Rule, e.Field)
+	case e.Rule >= 0:
+		fmt.Fprintf(&b, "rules[%d]: ", e.Rule)
+	case e.Field != "":
+		b.WriteString(e.Field + ": ")
+	}
+	b.WriteString(e.Err.Error())
+	return b.String()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// configError is a ParseError outside of the rules, field being empty when
+// the error isn't about a single one
+func configError(kind ParseErrorKind, field string, err error) *ParseError {
+	return &ParseError{Kind: kind, Rule: -1, Field: field, Err: err}
+}
+
+// ruleError is a ParseError in the i-th rule
+func ruleError(kind ParseErrorKind, i int, field string, err error) *ParseError {
+	return &ParseError{Kind: kind, Rule: i, Field: field, Err: err}
+}
+
+// regexpError is a ParseError for pattern, which doesn't compile, rule being
+// -1 outside of the rules
+func regexpError(rule int, field, pattern string, err error) *ParseError {
+	return &ParseError{Kind: InvalidRegexp, Rule: rule, Field: field, Pattern: pattern, Err: err}
+}
+
+// inSource tells that err comes from the config source, which is included by
+// or parsed along with others
+func inSource(source string, err error) error {
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		return fmt.Errorf("%s: %s", source, err)
+	}
+	if parseErr.Source == "" {
+		parseErr.Source = source
+	} else {
+		parseErr.Source = source + ": " + parseErr.Source
+	}
+	return parseErr
+}
@@ -0,0 +1,210 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lidder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fixableRules are those of rules which -fix lets remove or rewrite lines of
+// filename with
+func (defs *Defs) fixableRules(rules []*Rule, filename string) []*Rule {
+	if !defs.Options.Fix {
+		return nil
+	}
+	var fixable []*Rule
+	for _, rule := range rules {
+		if rule.Fixable && !rule.isExpected(rule.unit(filename)) {
+			fixable = append(fixable, rule)
+		}
+	}
+	return fixable
+}
+
+// fix returns line, numbered lineno, as rules with Replace rewrite it, along
+// with how many substitutions they made, unless another rule removes it, which
+// remove tells. Suppressed lines are kept as is.
+func (defs *Defs) fix(rules []*Rule, lineno int, line string) (fixed string, replaced int, remove bool) {
+	if strings.Contains(line, defs.IgnoreMarker) {
+		return line, 0, false
+	}
+	// matching as scanning does, the line ending being left alone
+	text := trimEOL(line)
+	eol := line[len(text):]
+	for _, rule := range rules {
+		if rule.Replace == "" && rule.reaches(lineno) && rule.matchLine(text) {
+			return "", 0, true
+		}
+	}
+
+	for _, rule := range rules {
+		if rule.Replace == "" || !rule.reaches(lineno) || !rule.matchLine(text) {
+			continue
+		}
+		// comments are left alone by rules which skip them
+		code := rule.code(text)
+		replaced += len(rule.pattern.FindAllStringIndex(code, -1))
+		text = rule.pattern.ReplaceAllString(code, rule.Replace) + text[len(code):]
+		line = text + eol
+	}
+	return line, replaced, false
+}
+
+// rewrite replaces filename with its fixed content through a temporary file
+// renamed over it, so that it's never left half written
+func (defs *Defs) rewrite(filename string, bom, content []byte, removed, replaced int) error {
+	path := defs.path(filename)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // already gone once renamed
+
+	_, err = tmp.Write(bom)
+	if err == nil {
+		_, err = tmp.Write(content)
+	}
+	if err == nil {
+		err = tmp.Chmod(fi.Mode())
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot fix '%s': %s", filename, err)
+	}
+
+	defs.mu.Lock()
+	defer defs.mu.Unlock()
+	if removed != 0 {
+		if defs.fixed == nil {
+			defs.fixed = make(map[string]int)
+		}
+		defs.fixed[filename] = removed
+	}
+	if replaced != 0 {
+		if defs.replaced == nil {
+			defs.replaced = make(map[string]int)
+		}
+		defs.replaced[filename] = replaced
+	}
+	return nil
+}
+
+// lines of context around the fixed ones in diffs
+const diffContext = 3
+
+// recordDiff records how filename, made of lines, would change once the
+// changed ones, numbered from 1, are fixed
+func (defs *Defs) recordDiff(filename string, lines []string, changed map[int]string) {
+	diff := unifiedDiff(filepath.ToSlash(filename), lines, changed)
+
+	defs.mu.Lock()
+	defer defs.mu.Unlock()
+	if defs.diffs == nil {
+		defs.diffs = make(map[string]string)
+	}
+	defs.diffs[filename] = diff
+}
+
+// unifiedDiff is diff -u for replacing the changed lines with what they map
+// to, an empty string removing them, which is all fixes do
+func unifiedDiff(name string, lines []string, changed map[int]string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- a/%s\n+++ b/%s\n", name, name)
+
+	// lines before the hunk which were removed, shifting where it starts
+	// in the new file
+	shift := 0
+	for start := 1; start <= len(lines); {
+		if _, ok := changed[start]; !ok {
+			start++
+			continue
+		}
+
+		// the hunk spans the changed lines closer than twice the context
+		// to each other, along with the context around them
+		first := start - diffContext
+		if first < 1 {
+			first = 1
+		}
+		last := start
+		for n := start; n <= len(lines) && n <= last+2*diffContext; n++ {
+			if _, ok := changed[n]; ok {
+				last = n
+			}
+		}
+		end := last + diffContext
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		removed := 0
+		for n := first; n <= end; n++ {
+			if line, ok := changed[n]; ok && line == "" {
+				removed++
+			}
+		}
+		oldCount, newCount := end-first+1, end-first+1-removed
+		newFirst := first - shift
+		if newCount == 0 {
+			// diff -u points before an empty range
+			newFirst--
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", diffRange(first, oldCount), diffRange(newFirst, newCount))
+		for n := first; n <= end; n++ {
+			fixed, ok := changed[n]
+			if !ok {
+				writeDiffLine(&b, " ", lines[n-1])
+				continue
+			}
+			writeDiffLine(&b, "-", lines[n-1])
+			if fixed != "" {
+				writeDiffLine(&b, "+", fixed)
+			}
+		}
+
+		shift += removed
+		start = end + 1
+	}
+	return b.String()
+}
+
+// writeDiffLine writes line to a hunk, noting when it doesn't end with a
+// newline
+func writeDiffLine(b *strings.Builder, prefix, line string) {
+	b.WriteString(prefix + line)
+	if !strings.HasSuffix(line, "\n") {
+		b.WriteString("\n\\ No newline at end of file\n")
+	}
+}
+
+// diffRange is a hunk's range as diff -u writes it, the count left out when 1
+func diffRange(first, count int) string {
+	if count == 1 {
+		return fmt.Sprint(first)
+	}
+	return fmt.Sprintf("%d,%d", first, count)
+}
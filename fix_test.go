@@ -0,0 +1,169 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lidder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/stretchr/testify/require"
+)
+
+func (s *Zuite) TestFix() {
+	root := writeTree(s.T(), map[string]string{
+		"a.go":        "import (\n\t\"fmt\"\n\t\"old/log\"\n)\n\nvar _ = \"old/log\" // lidder:ignore\n\"old/log\"",
+		"expected.go": "\"old/log\"\n",
+		"other.go":    "panic(1)\n",
+	})
+	defer os.RemoveAll(root)
+	require.NoError(s.T(), os.Chmod(filepath.Join(root, "a.go"), 0600))
+
+	config := `
+include: [\.go$]
+rules:
+  - pattern: '"old/log"'
+    fixable: true
+    expected: [%s]
+  - pattern: panic\(
+`
+	for _, fix := range []bool{false, true} {
+		d, err := Parse([]byte(fmt.Sprintf(config, filepath.Join(root, "expected.go"))))
+		require.NoError(s.T(), err)
+		d.Options.Fix = fix
+		require.NoError(s.T(), d.exploreDir(root))
+
+		a := filepath.Join(root, "a.go")
+		content, err := ioutil.ReadFile(a)
+		require.NoError(s.T(), err)
+		if !fix {
+			require.Equal(s.T(), []Match{{Line: 3, Text: "\t\"old/log\""}}, d.Rules[0].matches[a])
+			require.Nil(s.T(), d.fixed)
+			continue
+		}
+
+		require.Equal(s.T(), "import (\n\t\"fmt\"\n)\n\nvar _ = \"old/log\" // lidder:ignore\n", string(content))
+		require.Equal(s.T(), map[string]int{a: 2}, d.fixed)
+		require.Equal(s.T(), map[string]bool{filepath.Join(root, "expected.go"): true}, d.Rules[0].actualFilenames)
+		require.True(s.T(), d.Rules[1].actualFilenames[filepath.Join(root, "other.go")])
+
+		fi, err := os.Stat(a)
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), os.FileMode(0600), fi.Mode())
+		content, err = ioutil.ReadFile(filepath.Join(root, "expected.go"))
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), "\"old/log\"\n", string(content))
+	}
+}
+
+func (s *Zuite) TestFixReplace() {
+	root := writeTree(s.T(), map[string]string{
+		"a.go":        "a, _ := ioutil.ReadFile(x)\nb, _ := ioutil.ReadFile(y) // lidder:ignore\nioutil.ReadFile(x); ioutil.ReadFile(y)\r\nremoved()\n",
+		"expected.go": "ioutil.ReadFile(x)\n",
+	})
+	defer os.RemoveAll(root)
+
+	d, err := Parse([]byte(`
+include: [\.go$]
+rules:
+  - pattern: ioutil\.(ReadFile|WriteFile)
+    fixable: true
+    replace: os.$1
+    expected: [expected.go]
+  - pattern: removed
+    fixable: true
+`))
+	require.NoError(s.T(), err)
+	d.Options.Fix = true
+	results, err := Run(d, root)
+	require.NoError(s.T(), err)
+
+	content, err := ioutil.ReadFile(filepath.Join(root, "a.go"))
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), "a, _ := os.ReadFile(x)\nb, _ := ioutil.ReadFile(y) // lidder:ignore\nos.ReadFile(x); os.ReadFile(y)\r\n", string(content))
+	require.Equal(s.T(), map[string]int{"a.go": 3}, results.Replaced)
+	require.Equal(s.T(), map[string]int{"a.go": 1}, results.Fixed)
+	require.Empty(s.T(), results.Rules[0].ShouldNotBeThere)
+	require.Empty(s.T(), results.Rules[0].ShouldBeThere)
+	content, err = ioutil.ReadFile(filepath.Join(root, "expected.go"))
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), "ioutil.ReadFile(x)\n", string(content))
+
+	_, err = Parse([]byte("rules:\n  - pattern: a\n    replace: b\n"))
+	require.Error(s.T(), err)
+}
+
+func (s *Zuite) TestFixableMultilineRules() {
+	_, err := Parse([]byte("rules:\n  - pattern: a\n    multiline: true\n    fixable: true\n"))
+	require.Error(s.T(), err)
+}
+
+func (s *Zuite) TestUnifiedDiff() {
+	lines := func(n int, last string) []string {
+		var lines []string
+		for i := 1; i < n; i++ {
+			lines = append(lines, fmt.Sprintf("%d\n", i))
+		}
+		return append(lines, last)
+	}
+
+	require.Equal(s.T(), `--- a/a.go
++++ b/a.go
+@@ -1,5 +1,4 @@
+ 1
+-2
+ 3
+ 4
+ 5
+@@ -8,5 +7,4 @@
+ 8
+ 9
+ 10
+-11
+ 12
+\ No newline at end of file
+`, unifiedDiff("a.go", lines(12, "12"), map[int]string{2: "", 11: ""}))
+
+	require.Equal(s.T(), `--- a/a.go
++++ b/a.go
+@@ -2,7 +2,6 @@
+ 2
+ 3
+ 4
+-5
+ 6
+ 7
+ 8
+`, unifiedDiff("a.go", lines(9, "9\n"), map[int]string{5: ""}))
+
+	require.Equal(s.T(), "--- a/a.go\n+++ b/a.go\n@@ -1 +0,0 @@\n-x\n", unifiedDiff("a.go", []string{"x\n"}, map[int]string{1: ""}))
+	require.Equal(s.T(), "--- a/a.go\n+++ b/a.go\n@@ -1,2 +1,2 @@\n-x\n+y\n z\n", unifiedDiff("a.go", []string{"x\n", "z\n"}, map[int]string{1: "y\n"}))
+}
+
+func (s *Zuite) TestFixDryRun() {
+	root := writeTree(s.T(), map[string]string{"a.go": "a\nold()\nb\n"})
+	defer os.RemoveAll(root)
+
+	d, err := Parse([]byte("include: [\\.go$]\nrules:\n  - pattern: old\\(\n    fixable: true\n"))
+	require.NoError(s.T(), err)
+	d.Options.Fix, d.Options.DryRun = true, true
+	results, err := Run(d, root)
+	require.NoError(s.T(), err)
+
+	require.Equal(s.T(), map[string]string{"a.go": "--- a/a.go\n+++ b/a.go\n@@ -1,3 +1,2 @@\n a\n-old()\n b\n"}, results.Diffs)
+	require.Nil(s.T(), results.Fixed)
+	content, err := ioutil.ReadFile(filepath.Join(root, "a.go"))
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), "a\nold()\nb\n", string(content))
+}
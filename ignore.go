@@ -0,0 +1,168 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lidder
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// a single line of a gitignore-style file
+type ignorePattern struct {
+	base    string
+	pattern *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreList holds patterns in the order they were read, later ones taking
+// precedence over earlier ones as in git
+type ignoreList []*ignorePattern
+
+// parseIgnore reads gitignore syntax; base is the directory holding the file,
+// against which its patterns are resolved
+func parseIgnore(base string, content []byte) (ignoreList, error) {
+	var list ignoreList
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		p := &ignorePattern{base: base}
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+
+		pattern, err := regexp.Compile(pathGlobToRegexp(line))
+		if err != nil {
+			return nil, err
+		}
+		p.pattern = pattern
+		list = append(list, p)
+	}
+	return list, scanner.Err()
+}
+
+// ignored reports whether path is excluded by the list; the last matching
+// pattern decides
+func (list ignoreList) ignored(path string, isDir bool) bool {
+	ignored := false
+	for _, p := range list {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		rel, err := filepath.Rel(p.base, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		if p.pattern.MatchString(filepath.ToSlash(rel)) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+// excludeRegexps turns gitignore syntax into regular expressions matching the
+// ignored paths and everything below them, for use as Exclude entries.
+// Negations can't be expressed that way, so they're rejected.
+func excludeRegexps(content []byte) ([]string, error) {
+	list, err := parseIgnore(".", content)
+	if err != nil {
+		return nil, err
+	}
+
+	exprs := make([]string, len(list))
+	for i, p := range list {
+		if p.negate {
+			return nil, errors.New("negated patterns aren't supported")
+		}
+		// patterns end with $, after which come the paths below
+		expr := strings.TrimSuffix(p.pattern.String(), "$")
+		if p.dirOnly {
+			exprs[i] = expr + "/.*$"
+		} else {
+			exprs[i] = expr + "(?:/.*)?$"
+		}
+	}
+	return exprs, nil
+}
+
+// pathGlobToRegexp anchors a glob to a relative path. A slash anywhere but at
+// the end anchors it to the start of the path, otherwise it matches the base
+// name at any depth. A trailing slash matches everything below a directory.
+func pathGlobToRegexp(glob string) string {
+	prefix := "(?:.*/)?"
+	if strings.Contains(strings.TrimSuffix(glob, "/"), "/") {
+		prefix = ""
+		glob = strings.TrimPrefix(glob, "/")
+	}
+	if strings.HasSuffix(glob, "/") {
+		glob += "**"
+	}
+	return "^" + prefix + globToRegexp(glob) + "$"
+}
+
+// globToRegexp translates a glob into an unanchored regular expression.
+// '*' and '?' never cross a '/', whereas '**' spans any number of directories.
+func globToRegexp(glob string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			buf.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			buf.WriteString(".*")
+			i++
+		case c == '*':
+			buf.WriteString("[^/]*")
+		case c == '?':
+			buf.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				buf.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			buf.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			buf.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,110 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lidder
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/stretchr/testify/require"
+)
+
+func (s *Zuite) TestIgnoreList() {
+	list, err := parseIgnore("", []byte(`
+# comment
+vendor/
+*.gen.go
+!keep.gen.go
+/top.go
+docs/**/*.go
+`))
+	require.NoError(s.T(), err)
+
+	require.True(s.T(), list.ignored("vendor", true))
+	require.True(s.T(), list.ignored("a/b/vendor", true))
+	require.False(s.T(), list.ignored("vendor", false))
+	require.True(s.T(), list.ignored("a/x.gen.go", false))
+	require.False(s.T(), list.ignored("a/keep.gen.go", false))
+	require.True(s.T(), list.ignored("top.go", false))
+	require.False(s.T(), list.ignored("a/top.go", false))
+	require.True(s.T(), list.ignored("docs/x.go", false))
+	require.True(s.T(), list.ignored("docs/a/b/x.go", false))
+	require.False(s.T(), list.ignored("a/docs/x.go", false))
+}
+
+func (s *Zuite) TestExploreDirWithGitignore() {
+	root := writeTree(s.T(), map[string]string{
+		".gitignore":         "vendor/\n*.gen.go\n",
+		"main.go":            "panic(1)\n",
+		"x.gen.go":           "panic(1)\n",
+		"vendor/dep.go":      "panic(1)\n",
+		"sub/.gitignore":     "local.go\n",
+		"sub/local.go":       "panic(1)\n",
+		"sub/shared.go":      "panic(1)\n",
+		"other/local.go":     "panic(1)\n",
+		".git/hooks/hook.go": "panic(1)\n",
+	})
+	defer os.RemoveAll(root)
+
+	scan := func(gitignore bool) []string {
+		d, err := Parse([]byte("include: [\\.go$]\nrules:\n  - pattern: panic\\(\n"))
+		require.NoError(s.T(), err)
+		d.Options.Gitignore = gitignore
+		// so that .git is left out by Gitignore alone
+		d.Options.Hidden = true
+		require.NoError(s.T(), d.exploreDir(root))
+
+		var found []string
+		for filename := range d.Rules[0].actualFilenames {
+			rel, err := filepath.Rel(root, filename)
+			require.NoError(s.T(), err)
+			found = append(found, filepath.ToSlash(rel))
+		}
+		sort.Strings(found)
+		return found
+	}
+
+	require.Equal(s.T(), []string{"main.go", "other/local.go", "sub/shared.go"}, scan(true))
+	require.Equal(s.T(), 7, len(scan(false)))
+}
+
+func (s *Zuite) TestAddIgnoreFile() {
+	d, err := Parse([]byte(`
+include: [\.go$]
+rules:
+  - pattern: panic\(
+  - pattern: TODO
+    exclude: [_test\.go$]
+`))
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), d.addIgnoreFile([]byte("# generated\n*.pb.go\n/build\nthird_party/\n")))
+
+	for filename, expected := range map[string]bool{
+		"main.go":                true,
+		"api/x.pb.go":            false,
+		"build/out.go":           false,
+		"build":                  false,
+		"a/build/x.go":           true,
+		"third_party/lib/dep.go": false,
+		"a/third_party/dep.go":   false,
+	} {
+		require.Equal(s.T(), expected, d.ShouldCheck(filename), filename)
+		for _, rule := range d.Rules {
+			require.Equal(s.T(), expected, rule.shouldCheck(filename), filename)
+		}
+	}
+	require.False(s.T(), d.Rules[1].shouldCheck("main_test.go"))
+
+	require.Error(s.T(), d.addIgnoreFile([]byte("*.go\n!main.go\n")))
+}
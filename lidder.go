@@ -10,256 +10,2749 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-package main
+// Package lidder checks that lidded patterns are only found where expected:
+// a config lists rules, each a pattern along with the files it may be found
+// in, which Run checks a tree against.
+package lidder
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
 	"gopkg.in/yaml.v2"
 )
 
-type defs struct {
-	Include []string
-	Exclude []string
-	Rules   []*rule
+// Defs is a config, as read by Parse, ready for a single Run
+type Defs struct {
+	Include    []string `yaml:"include"`
+	Exclude    []string `yaml:"exclude"`
+	Rules      []*Rule  `yaml:"rules"`
+	IgnoreCase bool     `yaml:"ignore_case"` // applies to Include and Exclude
+	// without any Include, every file but those Exclude excludes is
+	// scanned, rather than none
+	IncludeAll bool `yaml:"include_all"`
+	// how Include and Exclude are written: regex (the default) or glob
+	PatternSyntax string `yaml:"pattern_syntax"`
+	// lines containing this marker don't count as matches, defaults to lidder:ignore
+	IgnoreMarker string `yaml:"ignore_marker"`
+	// a command, run within the root, which each file is piped through,
+	// rules being matched against its output instead
+	Preprocess string `yaml:"preprocess"`
+	// configs merged before this one, as if given first to ParseConfigs,
+	// relative to its directory
+	IncludeConfigs []string `yaml:"include_configs"`
+	// what the Expected entries of the rules are relative to: root, the
+	// default, for the directory scanned, or config for the directory of
+	// the config, or the current one when it wasn't read from a file
+	ExpectedBase string `yaml:"expected_base"`
+	// top-level keys starting with x-, which hold what the rules share
+	// through YAML anchors, as in x-defaults: &defaults
+	Extensions map[string]interface{} `yaml:",inline"`
 
 	include []*regexp.Regexp
 	exclude []*regexp.Regexp
+	// set by -ext, which narrows the includes of every rule
+	extension *regexp.Regexp
+	// the patterns of the rules which are plain strings, all looked for at
+	// once
+	literals *literalSet
+	// environment variables referenced by the config but not set
+	unsetVars []string
+	// where the config comes from, for errors once merged
+	source string
+
+	// how to run, which the config doesn't say
+	Options Options `yaml:"-"`
+
+	// directory against which filenames are resolved, they are otherwise
+	// reported and compared with Expected as is
+	root string
+	// how many lines were removed from each file fixed
+	fixed map[string]int
+	// how many substitutions were made in each file fixed
+	replaced map[string]int
+	// how each file would be fixed, with Options.DryRun
+	diffs map[string]string
+	// what was scanned, and how fast
+	scanned Stats
+	// the matches of the previous run, for Options.Cache
+	cache *cache
+	// the files and directories skipped as they couldn't be read
+	unreadable []error
+	// the files given up on after Options.Timeout
+	timedOut []string
+	// the files being scanned when Options.Interrupt was done
+	stopped []string
+	// guards the rules' match maps while workers record into them
+	mu sync.Mutex
+}
+
+// Options tune a Run, the zero value scanning every file in scope one at a
+// time
+type Options struct {
+	// number of files matched concurrently
+	Jobs int
+	// skip whatever .gitignore files met during traversal ignore
+	Gitignore bool
+	// skip the paths listed by the .lidderignore file at the root, if any
+	LidderIgnore bool
+	// record every matching line rather than only the first one per file
+	AllMatches bool
+	// scan files which look binary too
+	IncludeBinary bool
+	// walk into symlinked directories and scan symlinked files
+	FollowSymlinks bool
+	// walk into hidden directories, such as .git, and scan hidden files,
+	// those whose name starts with a dot, which are skipped otherwise
+	Hidden bool
+	// files larger than this many bytes are skipped, 0 meaning no limit
+	MaxFileSize int64
+	// only scan files with one of these extensions, narrowing every include
+	Extensions []string
+	// only run the rules tagged with one of these, untagged rules being
+	// left out too
+	Tags []string
+	// log what's being scanned or skipped, and why, to stderr
+	Verbose bool
+	// when not nil, scans lasting more than a couple of seconds report how
+	// many files they went through so far there, on a line rewritten as it
+	// goes
+	Progress io.Writer
+	// how many lines before and after each match to keep, grep -C style
+	Context int
+	// remove the lines matched by fixable rules, see fix.go
+	Fix bool
+	// with Fix, leave files untouched, Results.Diffs telling how they'd
+	// change instead
+	DryRun bool
+	// grandfathered unexpected matches, which are then forgotten
+	Baseline *Baseline
+	// when not nil, only these files are scanned, as if they were the only
+	// ones in the tree, instead of walking the root. Directories among them
+	// are walked.
+	Files []string
+	// when set, the entries of this .tar, .tar.gz or .zip file are scanned
+	// instead of the root, named by their path within it
+	Archive string
+	// fail on the first file or directory which can't be read, rather than
+	// skipping it and carrying on
+	Strict bool
+	// when set, what the rules matched in each file is kept in this file, so
+	// that the next run only scans the files which changed; it's not used
+	// with Fix or Archive
+	Cache string
+	// when set, how long scanning a file may take, checked between lines,
+	// before it's given up on and listed in Results.TimedOut
+	Timeout time.Duration
+	// when not nil, scanning stops once it's done, such as on ^C,
+	// Results.Partial then telling that only some files were scanned
+	Interrupt context.Context
 }
 
-type rule struct {
-	Pattern  string
-	Expected []string
+// Rule lids a pattern, which should only be found in the files it expects
+type Rule struct {
+	// what reports call the rule, defaulting to its label or else its pattern
+	Name string `yaml:"name"`
+	// explains why the pattern is lidded, and what to do instead
+	Message string `yaml:"message"`
+	Pattern string `yaml:"pattern"`
+	// a file, relative to the config, listing patterns one per line instead
+	// of Pattern, blank lines and those starting with # left out, any of
+	// which matching being a match
+	PatternFile string `yaml:"pattern_file"`
+	// lines which also match this don't count, standing in for lookaheads
+	Unless string `yaml:"unless"`
+	// lines which also match any of these are allowed, as with Unless, such
+	// as the lines of banned markers which name their ticket
+	ExpectedLine []string `yaml:"expected_line"`
+	// the files, or globs, the pattern may be found in, relative to the root
+	// unless the config's ExpectedBase says otherwise
+	Expected []string `yaml:"expected"`
+	// a file, relative to the config, listing more Expected entries one
+	// per line, blank lines and those starting with # left out, such as a
+	// generated allowlist
+	ExpectedFile string `yaml:"expected_file"`
+	IgnoreCase   bool   `yaml:"ignore_case"`
+	// only match the pattern as a whole word, as if wrapped in \b, except
+	// on the sides where it is anchored with ^ or $
+	WholeWord bool `yaml:"whole_word"`
+	// only match the pattern before the comment a line may end with, which
+	// starts with CommentPrefix, // by default, outside of quotes
+	SkipComments  bool   `yaml:"skip_comments"`
+	CommentPrefix string `yaml:"comment_prefix"`
+	// match against whole files rather than line by line; the pattern
+	// needs (?s) for . to cross newlines
+	Multiline bool `yaml:"multiline"`
+	// every scanned file must match, except those listed in Expected
+	RequireAll bool `yaml:"require_all"`
+	// the pattern may be found nowhere, no exceptions, which an empty
+	// Expected says too but without being reported as loudly
+	Forbidden bool `yaml:"forbidden"`
+	// files listed in Expected may also not match, only the pattern being
+	// found elsewhere failing the rule
+	AllowMissingExpected bool `yaml:"allow_missing_expected"`
+	// how many files, expected or not, the pattern must be found in at
+	// least, and at most, either bound being unset when 0
+	MinFiles int `yaml:"min_files"`
+	MaxFiles int `yaml:"max_files"`
+	// only the first lines of files are matched, as many as this, such as
+	// for license headers, all of them when 0
+	MaxLines int `yaml:"max_lines"`
+	// narrow or widen the files the rule applies to, each falling back to
+	// the global list when absent
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+	// only files starting with a #! line whose interpreter matches this,
+	// such as "/usr/bin/env bash", are matched, whatever their extension
+	Shebang string `yaml:"shebang"`
+	// with -fix, unexpected matching lines are removed from files
+	Fixable bool `yaml:"fixable"`
+	// with -fix, what the pattern matches in unexpected lines is replaced
+	// with this instead of removing them, $1 standing for its first group
+	Replace string `yaml:"replace"`
+	// what the pattern is matched against: content, line by line, by
+	// default, or path for the filename itself, to lid where files live
+	Target string `yaml:"target"`
+	// what the pattern is found in: file, the default, or dir for the
+	// directory of the files matched, a unit split across several files,
+	// Expected then listing directories
+	Scope string `yaml:"scope"`
+	// groups the rule with others, so that a run may select some by tag
+	Tags []string `yaml:"tags"`
+	// how much a mismatch matters: error, the default, warning or info
+	Severity string `yaml:"severity"`
+	// replaces the global Preprocess command for the rule, - meaning none
+	Preprocess string `yaml:"preprocess"`
+	// patterns which must each match a line, in this order; files where
+	// one is missing or comes too early are those the rule finds, Pattern
+	// only naming it and defaulting to them joined
+	Ordered []string `yaml:"ordered"`
 
 	pattern           *regexp.Regexp
+	unless            *regexp.Regexp
+	shebang           *regexp.Regexp
+	ordered           []*regexp.Regexp
+	include           []*regexp.Regexp
+	extension         *regexp.Regexp
+	exclude           []*regexp.Regexp
 	expectedFilenames map[string]bool
+	expectedGlobs     []*expectedGlob
 	actualFilenames   map[string]bool
+	matches           map[string][]Match
+	matchCounts       map[string]int
+	scannedFilenames  map[string]bool
+	// only some files were scanned, so MinFiles can't be checked
+	partial bool
+	// the directory Expected is relative to, until made relative to the
+	// root, when the config's ExpectedBase is config
+	expectedBase string
+	// the index of the pattern in Defs.literals when it's a plain string
+	// there, or else -1
+	literal int
+}
+
+// ruleFields are the keys a rule may hold. A key with no value which isn't one
+// of them labels the rule, as in "- no testing package:".
+var ruleFields = map[string]bool{
+	"name": true, "message": true, "pattern": true, "unless": true, "expected": true,
+	"ignore_case": true, "whole_word": true, "multiline": true, "require_all": true,
+	"allow_missing_expected": true, "include": true, "exclude": true,
+	"fixable": true, "replace": true, "target": true,
+	"tags": true, "severity": true, "ordered": true,
+	"preprocess": true, "min_files": true, "max_files": true, "pattern_file": true,
+	"max_lines": true, "shebang": true, "scope": true,
+	"skip_comments": true, "comment_prefix": true, "forbidden": true,
+	"expected_file": true, "expected_line": true,
+}
+
+// UnmarshalYAML decodes a rule strictly once its label, if any, is set aside
+// to name it
+func (r *Rule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var fields yaml.MapSlice
+	err := unmarshal(&fields)
+	if err != nil {
+		return err
+	}
+
+	var (
+		kept    yaml.MapSlice
+		label   string
+		labeled bool
+	)
+	for _, field := range fields {
+		key, _ := field.Key.(string)
+		switch {
+		case ruleFields[key]:
+			kept = append(kept, field)
+		case field.Value == nil && !labeled:
+			label, labeled = fmt.Sprint(field.Key), true
+		default:
+			return fmt.Errorf("unknown rule field '%v'", field.Key)
+		}
+	}
+	kept, err = withMerged(unmarshal, fields, kept)
+	if err != nil {
+		return err
+	}
+
+	content, err := yaml.Marshal(kept)
+	if err != nil {
+		return err
+	}
+	type plain Rule
+	err = yaml.UnmarshalStrict(content, (*plain)(r))
+	if err != nil {
+		return err
+	}
+	if r.Name == "" {
+		r.Name = label
+	}
+	if r.Pattern == "" && len(r.Ordered) != 0 {
+		r.Pattern = strings.Join(r.Ordered, " then ")
+	}
+	return nil
 }
 
-func parse(input []byte) (*defs, error) {
-	// yaml parse
-	var defs defs
-	err := yaml.Unmarshal([]byte(input), &defs)
+// withMerged is kept along with the fields which a << merge key brings into
+// the rule, which decoding into a MapSlice drops, those the rule sets itself
+// taking precedence
+func withMerged(unmarshal func(interface{}) error, fields, kept yaml.MapSlice) (yaml.MapSlice, error) {
+	var all map[interface{}]interface{}
+	err := unmarshal(&all)
 	if err != nil {
 		return nil, err
 	}
+	set := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		set[fmt.Sprint(field.Key)] = true
+	}
+	var merged []string
+	for key := range all {
+		if !set[fmt.Sprint(key)] {
+			merged = append(merged, fmt.Sprint(key))
+		}
+	}
+	sort.Strings(merged)
+	for _, key := range merged {
+		if !ruleFields[key] {
+			return nil, fmt.Errorf("unknown rule field '%s'", key)
+		}
+		kept = append(kept, yaml.MapItem{Key: key, Value: all[key]})
+	}
+	return kept, nil
+}
 
-	// compile all patterns: include, exclue, and all rules' pattern
-	defs.include = make([]*regexp.Regexp, len(defs.Include))
-	for i, expr := range defs.Include {
-		pattern, err := regexp.Compile(expr)
+// Title is how reports refer to the rule: its name alongside its pattern, or
+// the pattern alone when unnamed
+func (r *Rule) Title() string {
+	pattern := r.Pattern
+	// the patterns of a file are too many to show
+	if r.PatternFile != "" {
+		pattern = "patterns in " + r.PatternFile
+	}
+	if r.Name == "" {
+		return pattern
+	}
+	return fmt.Sprintf("%s (%s)", r.Name, pattern)
+}
+
+// Stats sums up what a Run scanned
+type Stats struct {
+	Files    int
+	Bytes    int64
+	Duration time.Duration
+	// files which weren't scanned again, their matches being cached
+	Cached int
+	// files some rule applied to, whether their content was then scanned,
+	// cached or skipped
+	Checked int
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// an Expected entry holding glob metacharacters, which stands for every file
+// it matches
+type expectedGlob struct {
+	glob    string
+	pattern *regexp.Regexp
+}
+
+// Match is where a rule's pattern was found within a file
+type Match struct {
+	// 0 for path rules, whose Text is the path matched, and for ordered
+	// rules missing a pattern altogether
+	Line int
+	Text string
+	// the file matched within the directory, for rules whose Scope is dir
+	File string
+	// the surrounding lines, when defs.Options.Context is set
+	Before []string
+	After  []string
+}
+
+// Parse reads a YAML config, whose include_configs are relative to the
+// current directory
+func Parse(input []byte) (*Defs, error) {
+	return ParseConfigs(Config{Content: input})
+}
+
+// Config is the content of a config along with the file it was read from,
+// if any, which its include_configs are relative to
+type Config struct {
+	Filename string
+	Content  []byte
+}
+
+// ParseConfigs merges several configs, as ParseMulti does, those they include
+// coming right before them
+func ParseConfigs(configs ...Config) (*Defs, error) {
+	var all []*Defs
+	for i, config := range configs {
+		source := fmt.Sprintf("config %d", i+1)
+		var stack []string
+		if config.Filename != "" {
+			source = config.Filename
+			if path, err := filepath.Abs(config.Filename); err == nil {
+				stack = []string{path}
+			}
+		}
+		loaded, err := config.load(stack)
+		if err != nil {
+			if len(configs) == 1 {
+				return nil, err
+			}
+			return nil, inSource(source, err)
+		}
+		loaded[len(loaded)-1].source = source
+		all = append(all, loaded...)
+	}
+
+	if len(all) == 1 {
+		defs := all[0]
+		err := defs.compile()
 		if err != nil {
 			return nil, err
 		}
-		defs.include[i] = pattern
+		if len(defs.Rules) == 0 {
+			return nil, configError(MissingField, "", errNoRules)
+		}
+		return defs, nil
+	}
+	return merge(all)
+}
+
+// load decodes the config after those it includes, stack being the files
+// including it, to catch cycles
+func (c Config) load(stack []string) ([]*Defs, error) {
+	defs, err := unmarshalConfig(c.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	switch defs.ExpectedBase {
+	case "", "root":
+	case "config":
+		for _, rule := range defs.Rules {
+			rule.expectedBase = c.resolve(".")
+		}
+	default:
+		return nil, configError(InvalidValue, "expected_base", fmt.Errorf("unknown value '%s', expected root or config", defs.ExpectedBase))
 	}
 
-	defs.exclude = make([]*regexp.Regexp, len(defs.Exclude))
-	for i, expr := range defs.Exclude {
-		pattern, err := regexp.Compile(expr)
+	for i, rule := range defs.Rules {
+		if rule.ExpectedFile != "" {
+			expected, err := readExpectedFile(i, c.resolve(rule.ExpectedFile))
+			if err != nil {
+				return nil, err
+			}
+			rule.Expected = append(rule.Expected, expected...)
+		}
+
+		if rule.PatternFile == "" {
+			continue
+		}
+		if rule.Pattern != "" {
+			return nil, ruleError(InvalidValue, i, "", errors.New("pattern and pattern_file can't both be set"))
+		}
+		rule.Pattern, err = readPatternFile(i, c.resolve(rule.PatternFile))
 		if err != nil {
 			return nil, err
 		}
-		defs.exclude[i] = pattern
 	}
 
-	for _, rule := range defs.Rules {
-		pattern, err := regexp.Compile(rule.Pattern)
+	var all []*Defs
+	for _, filename := range defs.IncludeConfigs {
+		filename = c.resolve(filename)
+		path, err := filepath.Abs(filename)
 		if err != nil {
 			return nil, err
 		}
-		rule.pattern = pattern
+		for i, including := range stack {
+			if including == path {
+				return nil, configError(InvalidValue, "", fmt.Errorf("include_configs cycle: %s", strings.Join(append(stack[i:], path), " includes ")))
+			}
+		}
+
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, configError(UnreadableFile, "include_configs", err)
+		}
+		included, err := Config{filename, content}.load(append(stack[:len(stack):len(stack)], path))
+		if err != nil {
+			return nil, inSource(filename, err)
+		}
+		included[len(included)-1].source = filename
+		all = append(all, included...)
+	}
+	return append(all, defs), nil
+}
+
+// resolve is filename relative to the directory of the config, or to the
+// current one when it wasn't read from a file
+func (c Config) resolve(filename string) string {
+	if filepath.IsAbs(filename) || c.Filename == "" {
+		return filename
 	}
+	return filepath.Join(filepath.Dir(c.Filename), filename)
+}
 
-	// initialize all maps
-	for _, rule := range defs.Rules {
-		rule.expectedFilenames = make(map[string]bool)
-		rule.actualFilenames = make(map[string]bool)
-		for _, path := range rule.Expected {
-			rule.expectedFilenames[path] = true
+// readPatternFile joins the patterns listed in filename, the pattern_file of
+// the rule-th rule, into one matching any of them
+func readPatternFile(rule int, filename string) (string, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", ruleError(UnreadableFile, rule, "pattern_file", err)
+	}
+
+	var patterns []string
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := regexp.Compile(line); err != nil {
+			return "", regexpError(rule, "pattern_file", line, fmt.Errorf("%s:%d: %s", filename, i+1, err))
 		}
+		patterns = append(patterns, "(?:"+line+")")
+	}
+	if len(patterns) == 0 {
+		return "", ruleError(MissingField, rule, "pattern_file", fmt.Errorf("%s lists no patterns", filename))
+	}
+	return strings.Join(patterns, "|"), nil
+}
+
+// readExpectedFile lists the entries of filename, the expected_file of the
+// rule-th rule
+func readExpectedFile(rule int, filename string) ([]string, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, ruleError(UnreadableFile, rule, "expected_file", err)
 	}
 
-	return &defs, nil
+	var expected []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		expected = append(expected, line)
+	}
+	return expected, nil
 }
 
-// for single file mode, make it expect *only* the single file if it was expected
-func (defs *defs) adjustExpectedFilenames(filename string) {
-	for _, r := range defs.Rules {
-		newExpectedFilenames := make(map[string]bool)
-		if r.expectedFilenames[filename] {
-			newExpectedFilenames[filename] = true
+// a run without rules would always pass, which is surely a mistake
+var errNoRules = errors.New("config defines no rules")
+
+// unmarshalConfig decodes a single config, with environment variables expanded
+func unmarshalConfig(input []byte) (*Defs, error) {
+	// a struct happily decodes from nothing, so check what's there first
+	var node interface{}
+	err := yaml.Unmarshal(input, &node)
+	if err != nil {
+		return nil, configError(InvalidYAML, "", err)
+	}
+	if node == nil {
+		return nil, configError(MissingField, "", errors.New("config is empty, it must define at least include and rules"))
+	}
+	if _, ok := node.(map[interface{}]interface{}); !ok {
+		return nil, configError(InvalidYAML, "", errors.New("config must be a mapping, defining at least include and rules"))
+	}
+
+	var defs Defs
+	err = yaml.UnmarshalStrict(input, &defs)
+	if err != nil {
+		return nil, configError(InvalidYAML, "", err)
+	}
+	var unknown []string
+	for key := range defs.Extensions {
+		if !strings.HasPrefix(key, "x-") {
+			unknown = append(unknown, key)
 		}
-		r.expectedFilenames = newExpectedFilenames
 	}
+	if len(unknown) != 0 {
+		sort.Strings(unknown)
+		return nil, configError(InvalidYAML, "", fmt.Errorf("field %s not found, only keys starting with x- may be added", unknown[0]))
+	}
+	defs.expandEnv()
+	return &defs, nil
+}
+
+// ParseMulti merges several configs, in order. Include and Exclude lists are
+// concatenated and rules appended, except that a rule with the same pattern as
+// an earlier one replaces it, so that a repository's config can override the
+// rules of a shared one. The ignore marker is likewise the last one set. A
+// config with include_all and no includes of its own brings an include
+// matching every file, which holds whatever the others include.
+func ParseMulti(inputs ...[]byte) (*Defs, error) {
+	configs := make([]Config, len(inputs))
+	for i, input := range inputs {
+		configs[i].Content = input
+	}
+	return ParseConfigs(configs...)
 }
 
-func (rule *rule) Mismatches() ([]string, []string) {
+// merge is ParseMulti once the configs are decoded, each knowing its source
+func merge(all []*Defs) (*Defs, error) {
 	var (
-		shouldNotBeThere = make([]string, 0)
-		shouldBeThere    = make([]string, 0)
+		merged Defs
+		seen   = make(map[string]int)
 	)
-	for actual := range rule.actualFilenames {
-		if !rule.expectedFilenames[actual] {
-			shouldNotBeThere = append(shouldNotBeThere, actual)
+	for _, d := range all {
+		merged.unsetVars = appendMissing(merged.unsetVars, d.unsetVars...)
+		if d.IgnoreMarker != "" {
+			merged.IgnoreMarker = d.IgnoreMarker
 		}
-	}
-	for expected := range rule.expectedFilenames {
-		if !rule.actualFilenames[expected] {
-			shouldBeThere = append(shouldBeThere, expected)
+		merged.IncludeAll = merged.IncludeAll || d.IncludeAll
+		err := d.compile()
+		if err != nil {
+			return nil, inSource(d.source, err)
+		}
+
+		// once merged, paths lose the syntax and case options of their
+		// config, and include_all becomes an include
+		merged.Include = appendMissing(merged.Include, regexpStrings(d.include)...)
+		merged.Exclude = appendMissing(merged.Exclude, d.pathRegexps(d.Exclude)...)
+		for _, rule := range d.Rules {
+			rule.Include = d.pathRegexps(rule.Include)
+			rule.Exclude = d.pathRegexps(rule.Exclude)
+			// the command of its config, which the merged one doesn't have
+			if rule.Preprocess == "" {
+				rule.Preprocess = d.Preprocess
+			}
+			if j, ok := seen[rule.Pattern]; ok {
+				merged.Rules[j] = rule
+				continue
+			}
+			seen[rule.Pattern] = len(merged.Rules)
+			merged.Rules = append(merged.Rules, rule)
 		}
 	}
-	return shouldNotBeThere, shouldBeThere
+
+	err := merged.compile()
+	if err != nil {
+		return nil, err
+	}
+	if len(merged.Rules) == 0 {
+		return nil, configError(MissingField, "", errNoRules)
+	}
+	return &merged, nil
 }
 
-func (defs *defs) matchAgainstLine(filename, line string) {
-	// for every line, match against all (would be nice to use channels for that)
+// expandEnv substitutes $VAR and ${VAR} in paths, patterns and Expected
+// entries, unset variables being replaced by nothing and remembered. \$ is
+// left alone so that patterns can still match a literal dollar sign.
+func (defs *Defs) expandEnv() {
+	expand := func(list ...*string) {
+		for _, s := range list {
+			escaped := strings.Replace(*s, `\$`, "\\\x00", -1)
+			expanded := os.Expand(escaped, func(name string) string {
+				value, ok := os.LookupEnv(name)
+				if !ok {
+					defs.unsetVars = appendMissing(defs.unsetVars, name)
+				}
+				return value
+			})
+			*s = strings.Replace(expanded, "\x00", "$", -1)
+		}
+	}
+	expandAll := func(lists ...[]string) {
+		for _, list := range lists {
+			for i := range list {
+				expand(&list[i])
+			}
+		}
+	}
+
+	expandAll(defs.Include, defs.Exclude, defs.IncludeConfigs)
 	for _, rule := range defs.Rules {
-		if rule.pattern.Match([]byte(line)) {
-			rule.actualFilenames[filename] = true
+		expand(&rule.Pattern, &rule.PatternFile, &rule.ExpectedFile)
+		expandAll(rule.Expected, rule.Include, rule.Exclude, rule.ExpectedLine)
+	}
+}
+
+func appendMissing(list []string, values ...string) []string {
+	for _, value := range values {
+		found := false
+		for _, existing := range list {
+			found = found || existing == value
+		}
+		if !found {
+			list = append(list, value)
 		}
 	}
+	return list
 }
 
-func (defs *defs) matchAgainstFile(filename string) error {
-	file, err := os.Open(filename)
-	if err != nil {
-		return err
+// compile validates the definitions, compiles their patterns and prepares
+// the rules for matching
+func (defs *Defs) compile() error {
+	var err error
+	if defs.IgnoreMarker == "" {
+		defs.IgnoreMarker = "lidder:ignore"
 	}
-	defer file.Close()
 
-	reader := bufio.NewReader(file)
-	for {
-		line, err := reader.ReadString('\n')
-		if err == io.EOF {
-			return nil
-		} else if err != nil {
-			return err
-		}
+	// compile all patterns: include, exclue, and all rules' pattern
+	if defs.PatternSyntax != "" && defs.PatternSyntax != "regex" && defs.PatternSyntax != "glob" {
+		return configError(InvalidValue, "pattern_syntax", fmt.Errorf("unknown value '%s', expected regex or glob", defs.PatternSyntax))
+	}
 
-		defs.matchAgainstLine(filename, line)
+	defs.include, err = defs.compilePaths(-1, "include", defs.Include)
+	if err != nil {
+		return err
+	}
+	if defs.IncludeAll && len(defs.Include) == 0 {
+		defs.include = []*regexp.Regexp{regexp.MustCompile(`^`)}
 	}
-}
 
-func (defs *defs) exploreDir(dirname string) error {
-	files, err := ioutil.ReadDir(dirname)
+	defs.exclude, err = defs.compilePaths(-1, "exclude", defs.Exclude)
 	if err != nil {
 		return err
 	}
 
-	for _, fi := range files {
-		filename := filepath.Join(dirname, fi.Name())
-		switch mode := fi.Mode(); {
-		case mode.IsDir():
-			err := defs.exploreDir(filename)
-			if err != nil {
-				return err
+	for i, rule := range defs.Rules {
+		rule.ordered = nil
+		if len(rule.Ordered) != 0 {
+			if rule.Multiline || rule.Fixable || rule.Unless != "" || len(rule.ExpectedLine) != 0 || rule.Target == "path" {
+				return ruleError(InvalidValue, i, "", errors.New("ordered rules can't be multiline, fixable, path ones or have unless or expected_line"))
 			}
-		case mode.IsRegular():
-			if defs.shouldCheck(filename) {
-				err := defs.matchAgainstFile(filename)
+			for j, expr := range rule.Ordered {
+				pattern, err := compile(expr, rule.IgnoreCase)
 				if err != nil {
-					return err
+					return regexpError(i, fmt.Sprintf("ordered[%d]", j), expr, err)
 				}
+				rule.ordered = append(rule.ordered, pattern)
+			}
+		}
+
+		expr := rule.Pattern
+		if rule.WholeWord {
+			expr = wholeWord(expr)
+		}
+		pattern, err := compile(expr, rule.IgnoreCase)
+		if err != nil && rule.ordered == nil {
+			return regexpError(i, "pattern", rule.Pattern, err)
+		}
+		rule.pattern = pattern
+		rule.unless, err = rule.compileUnless(i)
+		if err != nil {
+			return err
+		}
+		rule.shebang = nil
+		if rule.Shebang != "" {
+			rule.shebang, err = regexp.Compile(rule.Shebang)
+			if err != nil {
+				return regexpError(i, "shebang", rule.Shebang, err)
+			}
+		}
+		if rule.Fixable && rule.Multiline {
+			return ruleError(InvalidValue, i, "", errors.New("multiline rules can't be fixable"))
+		}
+		if rule.Replace != "" && !rule.Fixable {
+			return ruleError(InvalidValue, i, "", errors.New("replace is only for fixable rules"))
+		}
+		if rule.SkipComments && (rule.Multiline || rule.ordered != nil || rule.Target == "path") {
+			return ruleError(InvalidValue, i, "", errors.New("multiline, ordered and path rules can't skip_comments"))
+		}
+		if rule.CommentPrefix != "" && !rule.SkipComments {
+			return ruleError(InvalidValue, i, "", errors.New("comment_prefix is only for rules which skip_comments"))
+		}
+		if rule.Fixable && defs.preprocess(rule) != "" {
+			return ruleError(InvalidValue, i, "", errors.New("preprocessed rules can't be fixable"))
+		}
+		if rule.RequireAll && rule.AllowMissingExpected {
+			return ruleError(InvalidValue, i, "", errors.New("require_all rules can't allow_missing_expected"))
+		}
+		if rule.Forbidden && (len(rule.Expected) != 0 || rule.RequireAll || rule.AllowMissingExpected || rule.MinFiles != 0 || rule.MaxFiles != 0) {
+			return ruleError(InvalidValue, i, "forbidden", errors.New("forbidden rules can't have expected, require_all, allow_missing_expected, min_files or max_files"))
+		}
+		if rule.MinFiles < 0 || rule.MaxFiles < 0 {
+			return ruleError(InvalidValue, i, "", errors.New("min_files and max_files can't be negative"))
+		}
+		if rule.MaxFiles != 0 && rule.MinFiles > rule.MaxFiles {
+			return ruleError(InvalidValue, i, "", fmt.Errorf("min_files %d is over max_files %d", rule.MinFiles, rule.MaxFiles))
+		}
+		if rule.MaxLines < 0 {
+			return ruleError(InvalidValue, i, "max_lines", errors.New("can't be negative"))
+		}
+		if _, ok := severities[rule.Severity]; !ok {
+			return ruleError(InvalidValue, i, "", fmt.Errorf("unknown severity '%s', expected error, warning or info", rule.Severity))
+		}
+		switch rule.Target {
+		case "", "content":
+		case "path":
+			if rule.Fixable || rule.Multiline || rule.MaxLines != 0 || rule.Shebang != "" {
+				return ruleError(InvalidValue, i, "", errors.New("path rules can't be fixable, multiline or have max_lines or shebang"))
+			}
+		default:
+			return ruleError(InvalidValue, i, "", fmt.Errorf("unknown target '%s', expected content or path", rule.Target))
+		}
+		switch rule.Scope {
+		case "", "file", "dir":
+		default:
+			return ruleError(InvalidValue, i, "scope", fmt.Errorf("unknown value '%s', expected file or dir", rule.Scope))
+		}
+
+		rule.include = defs.include
+		if rule.Include != nil {
+			rule.include, err = defs.compilePaths(i, "include", rule.Include)
+			if err != nil {
+				return err
+			}
+		}
+		rule.exclude = defs.exclude
+		if rule.Exclude != nil {
+			rule.exclude, err = defs.compilePaths(i, "exclude", rule.Exclude)
+			if err != nil {
+				return err
 			}
 		}
 	}
 
+	defs.compileLiterals()
+
+	// initialize all maps
+	for i, rule := range defs.Rules {
+		rule.scannedFilenames = make(map[string]bool)
+		rule.actualFilenames = make(map[string]bool)
+		rule.partial = false
+		rule.matches = make(map[string][]Match)
+		rule.matchCounts = make(map[string]int)
+		err := rule.compileExpected(i)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func (defs *defs) shouldCheck(filename string) bool {
-	// prioritize exclusions over inclusions
-	// matching any means we don't process the file
-	for _, exclude := range defs.exclude {
-		if exclude.Match([]byte(filename)) {
-			return false
+// compileUnless compiles Unless and ExpectedLine into a single pattern, lines
+// matching any of them not counting, or nil when there are none
+func (rule *Rule) compileUnless(i int) (*regexp.Regexp, error) {
+	var exprs []string
+	if rule.Unless != "" {
+		if _, err := compile(rule.Unless, rule.IgnoreCase); err != nil {
+			return nil, regexpError(i, "unless", rule.Unless, err)
 		}
+		exprs = append(exprs, rule.Unless)
 	}
-	// matching any means we process the file
-	for _, include := range defs.include {
-		if include.Match([]byte(filename)) {
-			return true
+	for j, expr := range rule.ExpectedLine {
+		if _, err := compile(expr, rule.IgnoreCase); err != nil {
+			return nil, regexpError(i, fmt.Sprintf("expected_line[%d]", j), expr, err)
 		}
+		exprs = append(exprs, expr)
 	}
-	return false
+	switch len(exprs) {
+	case 0:
+		return nil, nil
+	case 1:
+		return compile(exprs[0], rule.IgnoreCase)
+	}
+	return compile("(?:"+strings.Join(exprs, ")|(?:")+")", rule.IgnoreCase)
 }
 
-func main() {
-	if len(os.Args) != 2 && len(os.Args) != 3 {
-		fmt.Println("usage: lidder config.yaml [file]")
-		fmt.Println("  -- If [file] is not specified, defaults to scanning all files from the current directory recursively")
-		os.Exit(1)
+// compileLiterals sets aside the line rules whose pattern is a plain string,
+// so that a line is searched for all of them at once rather than running
+// their regular expressions one after the other
+func (defs *Defs) compileLiterals() {
+	var literals []string
+	for _, rule := range defs.Rules {
+		rule.literal = -1
+		if rule.Multiline || rule.ordered != nil || rule.SkipComments {
+			continue
+		}
+		if literal, ok := literalOf(rule.pattern); ok {
+			rule.literal = len(literals)
+			literals = append(literals, literal)
+		}
 	}
-
-	config, err := ioutil.ReadFile(os.Args[1])
-	if err != nil {
-		oops(err)
+	defs.literals = nil
+	if len(literals) != 0 {
+		defs.literals = newLiteralSet(literals)
 	}
+}
 
-	results, err := parse(config)
-	if err != nil {
-		oops(err)
+// compileExpected sorts the Expected entries of the i-th rule into filenames
+// and globs
+func (rule *Rule) compileExpected(i int) error {
+	rule.expectedFilenames = make(map[string]bool)
+	rule.expectedGlobs = nil
+	for j, path := range rule.Expected {
+		// filenames are clean, ./a.go being scanned as a.go
+		clean := filepath.Clean(path)
+		if !strings.ContainsAny(path, "*?[") {
+			rule.expectedFilenames[clean] = true
+			continue
+		}
+		pattern, err := regexp.Compile("^" + globToRegexp(clean) + "$")
+		if err != nil {
+			return regexpError(i, fmt.Sprintf("expected[%d]", j), path, err)
+		}
+		rule.expectedGlobs = append(rule.expectedGlobs, &expectedGlob{path, pattern})
 	}
+	return nil
+}
 
-	singleFileMode := false
-	if len(os.Args) == 3 && results.shouldCheck(os.Args[2]) {
-		singleFileMode = true
-		results.adjustExpectedFilenames(os.Args[2])
-		err = results.matchAgainstFile(os.Args[2])
-	} else {
-		err = results.exploreDir(".")
-	}
+// rebaseExpected makes the Expected entries relative to their config
+// relative to root instead, which files are named relative to
+func (defs *Defs) rebaseExpected(root string) error {
+	absRoot, err := filepath.Abs(root)
 	if err != nil {
-		oops(err)
+		return err
 	}
-
-	testFailed := false
-	for _, rule := range results.Rules {
-		shouldNotBeThere, shouldBeThere := rule.Mismatches()
-		if len(shouldNotBeThere) != 0 || len(shouldBeThere) != 0 {
-			testFailed = true
-			if singleFileMode {
-				if len(shouldNotBeThere) != 0 {
-					fmt.Printf("Lidded pattern '%s' found\n", rule.Pattern)
-				} else if len(shouldBeThere) != 0 { // mutually exclusive for a single file
-					fmt.Printf("Lidded pattern '%s' expected but not found\n", rule.Pattern)
-				}
-			} else {
-				fmt.Println(rule.Pattern)
-				if len(shouldNotBeThere) != 0 {
-					fmt.Println("  didn't expect to find:")
-					for _, s := range shouldNotBeThere {
-						fmt.Print("   - ")
-						fmt.Println(s)
-					}
-				}
-				if len(shouldBeThere) != 0 {
-					fmt.Println("  expected exceptions which were missing:")
-					for _, s := range shouldBeThere {
-						fmt.Print("   - ")
-						fmt.Println(s)
-					}
-				}
+	for i, rule := range defs.Rules {
+		if rule.expectedBase == "" {
+			continue
+		}
+		for j, expected := range rule.Expected {
+			if filepath.IsAbs(expected) {
+				continue
+			}
+			path, err := filepath.Abs(filepath.Join(rule.expectedBase, expected))
+			if err != nil {
+				return err
 			}
+			rule.Expected[j], err = filepath.Rel(absRoot, path)
+			if err != nil {
+				return err
+			}
+		}
+		rule.expectedBase = ""
+		err := rule.compileExpected(i)
+		if err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	if testFailed {
-		fmt.Print("\nlid test failed. sorry.\n")
-		os.Exit(2)
+// wholeWord puts word boundaries around expr, leaving out those next to an
+// anchor which already bounds it
+func wholeWord(expr string) string {
+	start, end := `\b`, `\b`
+	if strings.HasPrefix(expr, "^") || strings.HasPrefix(expr, `\A`) {
+		start = ""
 	}
+	if strings.HasSuffix(expr, "$") && !strings.HasSuffix(expr, `\$`) || strings.HasSuffix(expr, `\z`) {
+		end = ""
+	}
+	return start + "(?:" + expr + ")" + end
+}
 
-	fmt.Println("ok\tlid on all the things, nothing to see here.")
+func compile(expr string, ignoreCase bool) (*regexp.Regexp, error) {
+	if ignoreCase {
+		expr = "(?i)" + expr
+	}
+	return regexp.Compile(expr)
 }
 
-func oops(err error) {
-	fmt.Fprintf(os.Stderr, "%s", err)
-	os.Exit(1)
+// compilePaths compiles Include or Exclude entries, errors naming the list
+// they come from, that of the rule-th rule or the global one for -1
+func (defs *Defs) compilePaths(rule int, list string, exprs []string) ([]*regexp.Regexp, error) {
+	patterns := make([]*regexp.Regexp, len(exprs))
+	for i, expr := range defs.pathRegexps(exprs) {
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, regexpError(rule, fmt.Sprintf("%s[%d]", list, i), exprs[i], err)
+		}
+		patterns[i] = pattern
+	}
+	return patterns, nil
+}
+
+// pathRegexps turns Include or Exclude entries into regular expressions
+// according to PatternSyntax and IgnoreCase
+func (defs *Defs) pathRegexps(exprs []string) []string {
+	if exprs == nil {
+		return nil
+	}
+	regexps := make([]string, len(exprs))
+	for i, expr := range exprs {
+		if defs.PatternSyntax == "glob" {
+			expr = pathGlobToRegexp(expr)
+		}
+		if defs.IgnoreCase {
+			expr = "(?i)" + expr
+		}
+		regexps[i] = expr
+	}
+	return regexps
+}
+
+// when scanning only some files, make it expect *only* those files if they were expected
+func (defs *Defs) adjustExpectedFilenames(filenames ...string) {
+	for _, r := range defs.Rules {
+		newExpectedFilenames := make(map[string]bool)
+		for _, filename := range filenames {
+			unit := r.unit(filepath.Clean(filename))
+			if r.isExpected(unit) {
+				newExpectedFilenames[unit] = true
+			}
+		}
+		r.expectedFilenames = newExpectedFilenames
+		r.expectedGlobs = nil
+		r.partial = true
+	}
+}
+
+// Mismatches lists the files where the pattern was found but not expected,
+// and those where it was expected but not found, each sorted so that reports
+// don't change from one run to the next
+func (rule *Rule) Mismatches() ([]string, []string) {
+	shouldNotBeThere, shouldBeThere := rule.mismatches()
+	return sortedUnique(shouldNotBeThere), sortedUnique(shouldBeThere)
+}
+
+// FileCountMismatch tells how many more files the pattern had to be found in
+// to reach MinFiles, and how many it was found in over MaxFiles. MinFiles is
+// only checked when the whole tree was scanned.
+func (rule *Rule) FileCountMismatch() (shortfall, excess int) {
+	found := len(rule.actualFilenames)
+	if rule.MinFiles != 0 && !rule.partial && found < rule.MinFiles {
+		shortfall = rule.MinFiles - found
+	}
+	if rule.MaxFiles != 0 && found > rule.MaxFiles {
+		excess = found - rule.MaxFiles
+	}
+	return shortfall, excess
+}
+
+func (rule *Rule) mismatches() ([]string, []string) {
+	var (
+		shouldNotBeThere = make([]string, 0)
+		shouldBeThere    = make([]string, 0)
+	)
+	if rule.RequireAll {
+		for scanned := range rule.scannedFilenames {
+			if !rule.actualFilenames[scanned] && !rule.isExpected(scanned) {
+				shouldBeThere = append(shouldBeThere, scanned)
+			}
+		}
+		return shouldNotBeThere, shouldBeThere
+	}
+
+	for actual := range rule.actualFilenames {
+		if !rule.isExpected(actual) {
+			shouldNotBeThere = append(shouldNotBeThere, actual)
+		}
+	}
+	if rule.AllowMissingExpected {
+		return shouldNotBeThere, shouldBeThere
+	}
+	for expected := range rule.expectedFilenames {
+		if !rule.actualFilenames[expected] {
+			shouldBeThere = append(shouldBeThere, expected)
+		}
+	}
+	// globs are missing when they match none of the actual files
+	for _, expected := range rule.expectedGlobs {
+		found := false
+		for actual := range rule.actualFilenames {
+			if expected.pattern.MatchString(actual) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			shouldBeThere = append(shouldBeThere, expected.glob)
+		}
+	}
+	return shouldNotBeThere, shouldBeThere
+}
+
+// scannedInFull is list but for the files, or directories, which weren't
+// scanned or were only partly, being among stopped
+func (rule *Rule) scannedInFull(list, stopped []string) []string {
+	cut := make(map[string]bool)
+	for _, filename := range stopped {
+		cut[rule.unit(filename)] = true
+	}
+	kept := list[:0]
+	for _, s := range list {
+		if rule.scannedFilenames[s] && !cut[s] {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// without is list but for the entries of sorted, keeping its order
+func without(list, sorted []string) []string {
+	kept := list[:0]
+	for _, s := range list {
+		if i := sort.SearchStrings(sorted, s); i == len(sorted) || sorted[i] != s {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// sortedUnique sorts list in place and drops its duplicates, such as a glob
+// listed twice in Expected
+func sortedUnique(list []string) []string {
+	sort.Strings(list)
+	unique := list[:0]
+	for i, s := range list {
+		if i == 0 || s != list[i-1] {
+			unique = append(unique, s)
+		}
+	}
+	return unique
+}
+
+// unit is what the rule finds its pattern in when it's in filename, which
+// is filename itself unless the rule's Scope is dir
+func (rule *Rule) unit(filename string) string {
+	if rule.Scope == "dir" {
+		return filepath.Dir(filename)
+	}
+	return filename
+}
+
+func (rule *Rule) isExpected(filename string) bool {
+	if rule.expectedFilenames[filename] {
+		return true
+	}
+	for _, expected := range rule.expectedGlobs {
+		if expected.pattern.MatchString(filename) {
+			return true
+		}
+	}
+	return false
+}
+
+func (defs *Defs) matchAgainstLine(filename string, lineno int, line string) {
+	defs.matchRulesAgainstLine(defs.Rules, filename, lineno, line)
+}
+
+// matchRulesAgainstLine returns the matches it recorded
+func (defs *Defs) matchRulesAgainstLine(rules []*Rule, filename string, lineno int, line string) []recordedMatch {
+	// a suppressed line still counts towards files expected to match, so
+	// annotating an expected occurrence doesn't make it go missing
+	suppressed := strings.Contains(line, defs.IgnoreMarker)
+
+	// the plain string patterns are all looked for in a single pass
+	var found []bool
+	if defs.literals != nil {
+		found = defs.literals.find(line)
+	}
+
+	// for every line, match against all
+	var recorded []recordedMatch
+	for _, rule := range rules {
+		if rule.Multiline || rule.ordered != nil || !rule.reaches(lineno) || suppressed && !rule.isExpected(rule.unit(filename)) {
+			continue
+		}
+		if rule.literal >= 0 {
+			if found == nil || !found[rule.literal] || rule.unless != nil && rule.unless.MatchString(line) {
+				continue
+			}
+		} else if !rule.matchLine(line) {
+			continue
+		}
+		if i := defs.record(rule, filename, lineno, line); i >= 0 {
+			recorded = append(recorded, recordedMatch{rule, i})
+		}
+	}
+	return recorded
+}
+
+// matchAgainstContent runs the multiline rules against a whole file, matches
+// being located at the line where they start
+func (defs *Defs) matchAgainstContent(rules []*Rule, filename string, content []byte) {
+	// so that $ matches at the end of lines of (?m) patterns, which only
+	// expect \n
+	content = bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+	for _, rule := range rules {
+		if !rule.Multiline {
+			continue
+		}
+
+		for _, loc := range rule.pattern.FindAllIndex(firstLines(content, rule.MaxLines), -1) {
+			start := bytes.LastIndexByte(content[:loc[0]], '\n') + 1
+			end := bytes.IndexByte(content[loc[0]:], '\n')
+			if end < 0 {
+				end = len(content)
+			} else {
+				end += loc[0]
+			}
+			line := string(content[start:end])
+			if strings.Contains(line, defs.IgnoreMarker) && !rule.isExpected(rule.unit(filename)) {
+				continue
+			}
+			if rule.unless != nil && rule.unless.MatchString(line) {
+				continue
+			}
+			defs.record(rule, filename, bytes.Count(content[:start], []byte("\n"))+1, line)
+		}
+	}
+}
+
+// orderedProgress follows how far each ordered rule got through a file
+type orderedProgress struct {
+	rules []*Rule
+	// the index of the pattern each rule waits for next
+	next []int
+	// where each rule's patterns first matched, even too early
+	first [][]Match
+}
+
+func newOrderedProgress(rules []*Rule) *orderedProgress {
+	p := &orderedProgress{}
+	for _, rule := range rules {
+		if rule.ordered != nil {
+			p.rules = append(p.rules, rule)
+			p.next = append(p.next, 0)
+			p.first = append(p.first, make([]Match, len(rule.ordered)))
+		}
+	}
+	return p
+}
+
+func (p *orderedProgress) advance(lineno int, line string) {
+	for i, rule := range p.rules {
+		if !rule.reaches(lineno) {
+			continue
+		}
+		for j, pattern := range rule.ordered {
+			if p.first[i][j].Line == 0 && pattern.MatchString(line) {
+				p.first[i][j] = Match{Line: lineno, Text: line}
+			}
+		}
+		if n := p.next[i]; n < len(rule.ordered) && rule.ordered[n].MatchString(line) {
+			p.next[i]++
+		}
+	}
+}
+
+// finishOrdered records the rules whose patterns didn't all match in order, where
+// the first one out of place was found, or at line 0 when it's missing
+func (defs *Defs) finishOrdered(p *orderedProgress, filename string) {
+	for i, rule := range p.rules {
+		n := p.next[i]
+		if n == len(rule.ordered) {
+			continue
+		}
+		m := p.first[i][n]
+		defs.record(rule, filename, m.Line, m.Text)
+	}
+}
+
+// reaches tells whether the rule matches line lineno, MaxLines allowing it
+func (rule *Rule) reaches(lineno int) bool {
+	return rule.MaxLines == 0 || lineno <= rule.MaxLines
+}
+
+// firstLines is the start of content, up to and including line n, or all of
+// it when n is 0
+func firstLines(content []byte, n int) []byte {
+	if n == 0 {
+		return content
+	}
+	end := 0
+	for ; n > 0; n-- {
+		i := bytes.IndexByte(content[end:], '\n')
+		if i < 0 {
+			return content
+		}
+		end += i + 1
+	}
+	return content[:end]
+}
+
+// lastLine is the last line any of rules matches, or 0 when one of them
+// matches all lines
+func lastLine(rules []*Rule) int {
+	last := 0
+	for _, rule := range rules {
+		if rule.MaxLines == 0 {
+			return 0
+		}
+		if rule.MaxLines > last {
+			last = rule.MaxLines
+		}
+	}
+	return last
+}
+
+// matchLine tells whether line matches the pattern but not Unless, the
+// comment it may end with aside when the rule skips comments
+func (rule *Rule) matchLine(line string) bool {
+	return rule.pattern.MatchString(rule.code(line)) && (rule.unless == nil || !rule.unless.MatchString(line))
+}
+
+// code is line up to the comment it ends with, if any, when the rule skips
+// comments, or else all of it. The comment prefix doesn't start one within
+// quotes, such as in "http://".
+func (rule *Rule) code(line string) string {
+	if !rule.SkipComments {
+		return line
+	}
+	prefix := rule.CommentPrefix
+	if prefix == "" {
+		prefix = "//"
+	}
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case strings.HasPrefix(line[i:], prefix):
+			return line[:i]
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		}
+	}
+	return line
+}
+
+// record returns the index of the match within the matches of the rule's unit
+// for filename, or -1 when only counted
+func (defs *Defs) record(rule *Rule, filename string, lineno int, line string) int {
+	defs.mu.Lock()
+	defer defs.mu.Unlock()
+
+	defs.logf("%s:%d matches '%s'", filename, lineno, rule.Pattern)
+	unit := rule.unit(filename)
+	rule.actualFilenames[unit] = true
+	rule.matchCounts[unit]++
+	m := Match{Line: lineno, Text: strings.TrimRight(line, "\r\n")}
+	if unit != filename {
+		m.File = filename
+	}
+	// a directory keeps the first match of each of its files, only the
+	// first of those being left once they're all scanned
+	if defs.Options.AllMatches || !matchedIn(rule.matches[unit], m.File) {
+		rule.matches[unit] = append(rule.matches[unit], m)
+		return len(rule.matches[unit]) - 1
+	}
+	return -1
+}
+
+// matchedIn tells whether matches has one in filename
+func matchedIn(matches []Match, filename string) bool {
+	for _, m := range matches {
+		if m.File == filename {
+			return true
+		}
+	}
+	return false
+}
+
+// sortDirMatches orders the matches of each directory, which workers may
+// have recorded in any order, by file then line, keeping only the first one
+// unless all is set
+func (rule *Rule) sortDirMatches(all bool) {
+	for dirname, matches := range rule.matches {
+		sort.SliceStable(matches, func(i, j int) bool {
+			if matches[i].File != matches[j].File {
+				return matches[i].File < matches[j].File
+			}
+			return matches[i].Line < matches[j].Line
+		})
+		if !all {
+			rule.matches[dirname] = matches[:1]
+		}
+	}
+}
+
+// a match stored while scanning a file, which is only ever scanned by a single
+// worker so that its index is stable
+type recordedMatch struct {
+	rule  *Rule
+	index int
+}
+
+// contextWindow follows a file line by line, keeping the last few lines for
+// those before a match and holding on to matches until the lines after them
+// are read
+type contextWindow struct {
+	size    int
+	recent  []string
+	pending []*pendingContext
+}
+
+type pendingContext struct {
+	recordedMatch
+	before []string
+	after  []string
+}
+
+// advance moves the window past line, on which recorded were found, and
+// returns the matches whose context is now complete
+func (w *contextWindow) advance(line string, recorded []recordedMatch) []*pendingContext {
+	var done []*pendingContext
+	waiting := w.pending[:0]
+	for _, p := range w.pending {
+		p.after = append(p.after, line)
+		if len(p.after) == w.size {
+			done = append(done, p)
+		} else {
+			waiting = append(waiting, p)
+		}
+	}
+	w.pending = waiting
+
+	for _, r := range recorded {
+		w.pending = append(w.pending, &pendingContext{r, append([]string(nil), w.recent...), nil})
+	}
+	w.recent = append(w.recent, line)
+	if len(w.recent) > w.size {
+		w.recent = w.recent[1:]
+	}
+	return done
+}
+
+// setContext stores the surroundings of matches into them
+func (defs *Defs) setContext(filename string, done []*pendingContext) {
+	if len(done) == 0 {
+		return
+	}
+	defs.mu.Lock()
+	defer defs.mu.Unlock()
+
+	for _, p := range done {
+		m := &p.rule.matches[p.rule.unit(filename)][p.index]
+		m.Before, m.After = p.before, p.after
+	}
+}
+
+func (defs *Defs) logf(format string, args ...interface{}) {
+	if defs.Options.Verbose {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+}
+
+// path locates filename on disk
+func (defs *Defs) path(filename string) string {
+	if filepath.IsAbs(filename) {
+		return filename
+	}
+	return filepath.Join(defs.root, filename)
+}
+
+func (defs *Defs) matchAgainstFile(filename string) error {
+	filename = filepath.Clean(filename)
+	ctx, cancel := defs.fileContext()
+	defer cancel()
+	return defs.checkTimeout(filename, defs.scanFile(ctx, filename))
+}
+
+// fileContext is the context a file is scanned within, which Options.Timeout
+// limits, within Options.Interrupt
+func (defs *Defs) fileContext() (context.Context, context.CancelFunc) {
+	parent := defs.Options.Interrupt
+	if parent == nil {
+		parent = context.Background()
+	}
+	if defs.Options.Timeout <= 0 {
+		return parent, func() {}
+	}
+	return context.WithTimeout(parent, defs.Options.Timeout)
+}
+
+// interrupted tells whether Options.Interrupt is done, and so scanning should
+// stop
+func (defs *Defs) interrupted() bool {
+	return defs.Options.Interrupt != nil && defs.Options.Interrupt.Err() != nil
+}
+
+// checkTimeout records filename as timed out when err says it did, or as
+// stopped when the scan was interrupted, neither of which fails the run
+func (defs *Defs) checkTimeout(filename string, err error) error {
+	if err != nil && defs.interrupted() && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		defs.logf("stopping %s: interrupted", filename)
+		defs.mu.Lock()
+		defer defs.mu.Unlock()
+		defs.stopped = append(defs.stopped, filename)
+		return nil
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	defs.logf("giving up on %s: timed out after %s", filename, defs.Options.Timeout)
+	defs.mu.Lock()
+	defer defs.mu.Unlock()
+	defs.timedOut = append(defs.timedOut, filename)
+	return nil
+}
+
+// scanFile is matchAgainstFile within ctx
+func (defs *Defs) scanFile(ctx context.Context, filename string) error {
+	rules := defs.matchAgainstPath(defs.rulesFor(filename), filename)
+	if needsInterpreter(rules) {
+		head, err := defs.readHead(filename)
+		if err != nil {
+			return err
+		}
+		rules = withInterpreter(rules, interpreter(head))
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	// what dir rules found in a file isn't kept apart from the rest of its
+	// directory, so it can't be cached
+	cache := defs.cache != nil && !anyDirScope(rules)
+	var fi os.FileInfo
+	if defs.Options.MaxFileSize > 0 || cache {
+		var err error
+		fi, err = os.Stat(defs.path(filename))
+		if err != nil {
+			return err
+		}
+		if defs.Options.MaxFileSize > 0 && fi.Size() > defs.Options.MaxFileSize {
+			defs.logf("skipping %s: %d bytes is over the maximum file size", filename, fi.Size())
+			return nil
+		}
+		if cache && defs.restoreCached(rules, filename, fi) {
+			return nil
+		}
+	}
+
+	rules, preprocessed := defs.splitPreprocessed(rules)
+	for _, command := range preprocessed.commands {
+		err := defs.matchPreprocessed(ctx, preprocessed.rules[command], filename, command)
+		if err != nil {
+			return err
+		}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	file, err := os.Open(defs.path(filename))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = defs.matchAgainstReader(ctx, rules, filename, file, true)
+	if err == nil && cache {
+		defs.storeCached(rules, filename, fi)
+	}
+	return err
+}
+
+// anyDirScope tells whether any of rules has dir Scope
+func anyDirScope(rules []*Rule) bool {
+	for _, rule := range rules {
+		if rule.Scope == "dir" {
+			return true
+		}
+	}
+	return false
+}
+
+// needsInterpreter tells whether any of rules has a Shebang, which the start
+// of files must then be read for
+func needsInterpreter(rules []*Rule) bool {
+	for _, rule := range rules {
+		if rule.shebang != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// readHead reads the first sniffLen bytes of filename, or fewer when it's
+// shorter
+func (defs *Defs) readHead(filename string) ([]byte, error) {
+	file, err := os.Open(defs.path(filename))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	head, err := bufio.NewReaderSize(file, sniffLen).Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return head, nil
+}
+
+// interpreter is what the #! line at the start of head runs the file with,
+// such as "/bin/sh -e", or "" when there's none
+func interpreter(head []byte) string {
+	if !bytes.HasPrefix(head, []byte("#!")) {
+		return ""
+	}
+	line := head[2:]
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	return strings.TrimSpace(string(line))
+}
+
+// withInterpreter leaves out of rules those whose Shebang doesn't match the
+// interpreter of a file, files without one matching none
+func withInterpreter(rules []*Rule, interpreter string) []*Rule {
+	var kept []*Rule
+	for _, rule := range rules {
+		if rule.shebang == nil || interpreter != "" && rule.shebang.MatchString(interpreter) {
+			kept = append(kept, rule)
+		}
+	}
+	return kept
+}
+
+// preprocess is the command the rule's files are piped through, if any
+func (defs *Defs) preprocess(rule *Rule) string {
+	switch command := strings.TrimSpace(rule.Preprocess); command {
+	case "":
+		return strings.TrimSpace(defs.Preprocess)
+	case "-":
+		return ""
+	default:
+		return command
+	}
+}
+
+// preprocessedRules are rules grouped by the command their files are piped
+// through, in the order the commands first appear
+type preprocessedRules struct {
+	commands []string
+	rules    map[string][]*Rule
+}
+
+// splitPreprocessed sets aside the rules whose files are preprocessed
+func (defs *Defs) splitPreprocessed(rules []*Rule) ([]*Rule, preprocessedRules) {
+	var (
+		plain        []*Rule
+		preprocessed = preprocessedRules{rules: make(map[string][]*Rule)}
+	)
+	for _, rule := range rules {
+		command := defs.preprocess(rule)
+		if command == "" {
+			plain = append(plain, rule)
+			continue
+		}
+		if preprocessed.rules[command] == nil {
+			preprocessed.commands = append(preprocessed.commands, command)
+		}
+		preprocessed.rules[command] = append(preprocessed.rules[command], rule)
+	}
+	return plain, preprocessed
+}
+
+// matchPreprocessed matches rules against the output of command, fed
+// filename on its stdin. The command is split on spaces, without a shell.
+func (defs *Defs) matchPreprocessed(ctx context.Context, rules []*Rule, filename, command string) error {
+	file, err := os.Open(defs.path(filename))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var stdout, stderr bytes.Buffer
+	args := strings.Fields(command)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = defs.root
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = file, &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		// killed as it took too long
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%s: %s", err, msg)
+		}
+		return fmt.Errorf("preprocessing %s with '%s': %s", filename, command, err)
+	}
+	return defs.matchAgainstReader(ctx, rules, filename, &stdout, false)
+}
+
+// rulesFor lists the rules which apply to filename
+func (defs *Defs) rulesFor(filename string) []*Rule {
+	var rules []*Rule
+	for _, rule := range defs.Rules {
+		if rule.shouldCheck(filename) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// matchAgainstPath runs the path rules among rules against filename itself,
+// returning the others, which still need its content
+func (defs *Defs) matchAgainstPath(rules []*Rule, filename string) []*Rule {
+	if len(rules) != 0 {
+		defs.mu.Lock()
+		defs.scanned.Checked++
+		defs.mu.Unlock()
+	}
+
+	var content []*Rule
+	for _, rule := range rules {
+		if rule.Target != "path" {
+			content = append(content, rule)
+			continue
+		}
+
+		defs.mu.Lock()
+		rule.scannedFilenames[rule.unit(filename)] = true
+		defs.mu.Unlock()
+		path := filepath.ToSlash(filename)
+		if rule.matchLine(path) {
+			defs.record(rule, filename, 0, path)
+		}
+	}
+	return content
+}
+
+// matchAgainstReader matches rules against the content of filename, read from
+// r, until ctx is done. Only files on disk can be fixed, which is what onDisk
+// tells.
+func (defs *Defs) matchAgainstReader(ctx context.Context, rules []*Rule, filename string, r io.Reader, onDisk bool) error {
+	multiline := false
+	for _, rule := range rules {
+		multiline = multiline || rule.Multiline
+	}
+
+	counter := &countingReader{Reader: r}
+	reader, bom, err := decodeBOM(bufio.NewReaderSize(counter, sniffLen))
+	if err != nil {
+		return err
+	}
+	if !defs.Options.IncludeBinary {
+		head, err := reader.Peek(sniffLen)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if isBinary(head) {
+			defs.logf("skipping %s: binary", filename)
+			return nil
+		}
+	}
+
+	var fixable []*Rule
+	if onDisk {
+		fixable = defs.fixableRules(rules, filename)
+	}
+	if len(fixable) != 0 && bom != nil && !bytes.Equal(bom, utf8BOM) {
+		defs.logf("not fixing %s: only UTF-8 files are rewritten", filename)
+		fixable = nil
+	}
+
+	defs.logf("scanning %s", filename)
+	defs.mu.Lock()
+	defs.scanned.Files++
+	for _, rule := range rules {
+		rule.scannedFilenames[rule.unit(filename)] = true
+	}
+	defs.mu.Unlock()
+	defer func() {
+		defs.mu.Lock()
+		defs.scanned.Bytes += counter.n
+		defs.mu.Unlock()
+	}()
+
+	// multiline rules need the whole file, which line by line matching then
+	// reads back from memory
+	if multiline {
+		content, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		defs.matchAgainstContent(rules, filename, content)
+		reader = bufio.NewReader(bytes.NewReader(content))
+	}
+
+	var window *contextWindow
+	if defs.Options.Context > 0 {
+		window = &contextWindow{size: defs.Options.Context}
+		// matches near the end of the file get fewer lines after them
+		defer func() { defs.setContext(filename, window.pending) }()
+	}
+
+	// the rest of the file is only read for fixing, when no rule matches it
+	last := 0
+	if len(fixable) == 0 {
+		last = lastLine(rules)
+	}
+
+	var (
+		fixed    bytes.Buffer
+		removed  int
+		replaced int
+		ordered  = newOrderedProgress(rules)
+		// with Options.DryRun, the lines read and what became of the fixed
+		// ones
+		original []string
+		changed  = make(map[int]string)
+	)
+	done := ctx.Done()
+	for lineno := 1; ; lineno++ {
+		select {
+		case <-done:
+			// nothing is fixed in a file only partly scanned
+			return ctx.Err()
+		default:
+		}
+
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if last != 0 && lineno == last {
+			err = io.EOF
+		}
+
+		// removed lines don't count as matches
+		if len(fixable) != 0 && line != "" {
+			if defs.Options.DryRun {
+				original = append(original, line)
+			}
+			fixedLine, n, remove := defs.fix(fixable, lineno, line)
+			switch {
+			case remove:
+				defs.logf("%s:%d removed", filename, lineno)
+				removed++
+				changed[lineno] = ""
+				line = ""
+			case n != 0:
+				defs.logf("%s:%d replaced %d times", filename, lineno, n)
+				replaced += n
+				changed[lineno] = fixedLine
+				line = fixedLine
+			}
+			fixed.WriteString(line)
+		}
+		// the last line may not end with a newline, or be nothing at all
+		if line != "" {
+			text := trimEOL(line)
+			recorded := defs.matchRulesAgainstLine(rules, filename, lineno, text)
+			ordered.advance(lineno, text)
+			if window != nil {
+				defs.setContext(filename, window.advance(text, recorded))
+			}
+		}
+
+		if err == io.EOF {
+			defs.finishOrdered(ordered, filename)
+			if removed == 0 && replaced == 0 {
+				return nil
+			}
+			if defs.Options.DryRun {
+				defs.recordDiff(filename, original, changed)
+				return nil
+			}
+			return defs.rewrite(filename, bom, fixed.Bytes(), removed, replaced)
+		}
+	}
+}
+
+// trimEOL strips line of its ending, \n or \r\n, so that $ matches at its end
+func trimEOL(line string) string {
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r")
+}
+
+// how much of a file is looked at to tell whether it's binary
+const sniffLen = 8000
+
+var (
+	utf8BOM    = []byte{0xef, 0xbb, 0xbf}
+	utf16LEBOM = []byte{0xff, 0xfe}
+	utf16BEBOM = []byte{0xfe, 0xff}
+)
+
+// decodeBOM strips a leading byte order mark, which it returns, and decodes
+// UTF-16 into UTF-8 so that lines are split and patterns matched as in any
+// other file. Files without a BOM are read as is.
+func decodeBOM(reader *bufio.Reader) (*bufio.Reader, []byte, error) {
+	head, err := reader.Peek(3)
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(head, utf8BOM):
+		_, err = reader.Discard(len(utf8BOM))
+		return reader, utf8BOM, err
+	case bytes.HasPrefix(head, utf16LEBOM), bytes.HasPrefix(head, utf16BEBOM):
+		decoder := unicode.BOMOverride(transform.Nop)
+		return bufio.NewReaderSize(transform.NewReader(reader, decoder), sniffLen), head[:2:2], nil
+	}
+	return reader, nil, nil
+}
+
+// isBinary guesses whether data is the start of a binary file: it is if it
+// holds a NUL byte, or if too much of it is neither printable nor UTF-8
+func isBinary(data []byte) bool {
+	if bytes.IndexByte(data, 0) >= 0 {
+		return true
+	}
+
+	nonText := 0
+	for i := 0; i < len(data); {
+		r, size := utf8.DecodeRune(data[i:])
+		switch {
+		case r == utf8.RuneError && size == 1 && len(data)-i >= utf8.UTFMax:
+			// invalid, unless it's a rune cut short by the end of data
+			nonText++
+		case r < 0x20 && r != '\t' && r != '\n' && r != '\r' && r != '\f' && r != '\b' && r != 0x1b:
+			nonText++
+		}
+		i += size
+	}
+	return nonText*10 > len(data)*3
+}
+
+// matchAgainstList scans the given files, and those under the given
+// directories, as if they were the only ones in the tree
+func (defs *Defs) matchAgainstList(filenames []string) error {
+	var (
+		checked []string
+		seen    = make(map[string]bool)
+	)
+	for _, filename := range filenames {
+		filename = filepath.Clean(filename)
+		fi, err := os.Stat(defs.path(filename))
+		if err != nil {
+			return fmt.Errorf("cannot scan '%s': %s", filename, err)
+		}
+
+		var found []string
+		switch {
+		case fi.IsDir():
+			ignores, err := defs.parentIgnores(filename)
+			if err != nil {
+				return err
+			}
+			found, err = defs.collectFiles(filename, ignores, make(map[string]bool))
+			if err != nil {
+				return err
+			}
+		case fi.Mode().IsRegular() && defs.ShouldCheck(filename):
+			found = []string{filename}
+		}
+		// a file may be given along with a directory holding it
+		for _, filename := range found {
+			if !seen[filename] {
+				seen[filename] = true
+				checked = append(checked, filename)
+			}
+		}
+	}
+
+	defs.adjustExpectedFilenames(checked...)
+	return defs.matchAgainstFiles(checked)
+}
+
+// parentIgnores are the .gitignore patterns in effect within dirname, from
+// the directories above it up to the root, when Options.Gitignore is set
+func (defs *Defs) parentIgnores(dirname string) (ignoreList, error) {
+	if !defs.Options.Gitignore || filepath.IsAbs(dirname) || dirname == "." || strings.HasPrefix(dirname, "..") {
+		return nil, nil
+	}
+
+	ignores, err := defs.readIgnoreFile(".", nil)
+	parent := filepath.Dir(dirname)
+	if err != nil || parent == "." {
+		return ignores, err
+	}
+	dir := "."
+	for _, name := range strings.Split(parent, string(filepath.Separator)) {
+		dir = filepath.Join(dir, name)
+		ignores, err = defs.readIgnoreFile(dir, ignores)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ignores, nil
+}
+
+// ReadFileList reads one path per line, ignoring blank lines, as a list for
+// Options.Files which is never nil
+func ReadFileList(r io.Reader) ([]string, error) {
+	filenames := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if filename := strings.TrimSpace(scanner.Text()); filename != "" {
+			filenames = append(filenames, filename)
+		}
+	}
+	return filenames, scanner.Err()
+}
+
+func (defs *Defs) exploreDir(dirname string) error {
+	filenames, err := defs.collectFiles(dirname, nil, make(map[string]bool))
+	if err != nil {
+		return err
+	}
+
+	return defs.matchAgainstFiles(filenames)
+}
+
+// UnsetVars lists the environment variables the config references but which
+// aren't set, and so were expanded to nothing
+func (defs *Defs) UnsetVars() []string {
+	return defs.unsetVars
+}
+
+// Paths lists the regular expressions which files are included and excluded
+// by, once globs are translated and environment variables expanded
+func (defs *Defs) Paths() (include, exclude []string) {
+	return regexpStrings(defs.include), regexpStrings(defs.exclude)
+}
+
+// Paths is Defs.Paths for the rule, which has the global lists unless it
+// has its own
+func (rule *Rule) Paths() (include, exclude []string) {
+	return regexpStrings(rule.include), regexpStrings(rule.exclude)
+}
+
+func regexpStrings(patterns []*regexp.Regexp) []string {
+	exprs := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		exprs[i] = pattern.String()
+	}
+	return exprs
+}
+
+// Run scans root, relative to which files are named, and tells how each rule
+// fared. Defs are good for a single Run.
+func Run(defs *Defs, root string) (Results, error) {
+	err := defs.prepare(root)
+	if err != nil {
+		return Results{}, err
+	}
+
+	useCache := defs.Options.Cache != "" && !defs.Options.Fix && defs.Options.Archive == ""
+	if useCache {
+		defs.cache, err = defs.readCache(defs.Options.Cache)
+		if err != nil {
+			return Results{}, fmt.Errorf("cache: %s", err)
+		}
+	}
+
+	start := time.Now()
+	if defs.Options.Progress != nil {
+		stop := defs.reportProgress(defs.Options.Progress)
+		defer stop()
+	}
+	if defs.Options.Archive != "" {
+		err = defs.matchAgainstArchive(defs.Options.Archive)
+	} else if defs.Options.Files != nil {
+		err = defs.matchAgainstList(defs.Options.Files)
+	} else {
+		err = defs.exploreDir(".")
+	}
+	if err != nil {
+		return Results{}, err
+	}
+	defs.scanned.Duration = time.Since(start)
+	// files which weren't scanned would be dropped from the cache
+	partial := defs.interrupted()
+	if useCache && !partial {
+		err = defs.writeCache(defs.Options.Cache)
+		if err != nil {
+			return Results{}, fmt.Errorf("cache: %s", err)
+		}
+	}
+	return defs.results(partial), nil
+}
+
+// MatchStrings is Run for files held in memory, which map filenames to their
+// content as if they were all the tree has, without touching the file system.
+// The options which are about files on disk, such as Fix or Cache, don't
+// apply, nor do Preprocess commands, and Expected is relative to the current
+// directory.
+func MatchStrings(defs *Defs, files map[string]string) (Results, error) {
+	err := defs.prepareRules(".")
+	if err != nil {
+		return Results{}, err
+	}
+
+	filenames := make([]string, 0, len(files))
+	for filename := range files {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	start := time.Now()
+	for _, filename := range filenames {
+		content := files[filename]
+		err = defs.matchAgainstEntry(filename, int64(len(content)), strings.NewReader(content))
+		if err != nil {
+			return Results{}, err
+		}
+	}
+	defs.scanned.Duration = time.Since(start)
+	return defs.results(false), nil
+}
+
+// results tells how each rule fared once scanning is over, partial telling
+// whether it was interrupted
+func (defs *Defs) results(partial bool) Results {
+	if defs.Options.Baseline != nil {
+		defs.applyBaseline(defs.Options.Baseline)
+	}
+
+	results := Results{
+		Rules:      make([]*RuleResult, len(defs.Rules)),
+		Stats:      defs.scanned,
+		Fixed:      defs.fixed,
+		Replaced:   defs.replaced,
+		Diffs:      defs.diffs,
+		Unreadable: defs.unreadable,
+		TimedOut:   sortedUnique(defs.timedOut),
+		Partial:    partial,
+	}
+	for i, rule := range defs.Rules {
+		if rule.Scope == "dir" {
+			rule.sortDirMatches(defs.Options.AllMatches)
+		}
+		shouldNotBeThere, shouldBeThere := rule.Mismatches()
+		// the pattern may well be in the part of a timed out file not scanned
+		if len(results.TimedOut) != 0 {
+			shouldBeThere = without(shouldBeThere, results.TimedOut)
+		}
+		// nor can anything be expected from the files an interrupted scan
+		// didn't get to
+		if partial {
+			rule.partial = true
+			shouldBeThere = rule.scannedInFull(shouldBeThere, defs.stopped)
+		}
+		shortfall, excess := rule.FileCountMismatch()
+		results.Rules[i] = &RuleResult{
+			Rule:             rule,
+			ShouldNotBeThere: shouldNotBeThere,
+			ShouldBeThere:    shouldBeThere,
+			Matches:          rule.matches,
+			MatchCounts:      rule.matchCounts,
+			Shortfall:        shortfall,
+			Excess:           excess,
+		}
+	}
+	return results
+}
+
+const (
+	// how long a scan goes on before reporting its progress, and how often
+	progressDelay    = 2 * time.Second
+	progressInterval = 250 * time.Millisecond
+)
+
+// reportProgress writes how many files were scanned to w until the returned
+// func is called, which ends the line if anything was written
+func (defs *Defs) reportProgress(w io.Writer) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		started, reported := time.Now(), false
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				if reported {
+					fmt.Fprintf(w, "\rscanned %d files\n", defs.scannedFiles())
+				}
+				return
+			case now := <-ticker.C:
+				if now.Sub(started) >= progressDelay {
+					fmt.Fprintf(w, "\rscanned %d files", defs.scannedFiles())
+					reported = true
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+func (defs *Defs) scannedFiles() int {
+	defs.mu.Lock()
+	defer defs.mu.Unlock()
+	return defs.scanned.Files
+}
+
+// Explain lists, for each rule, the files under root it would scan, without
+// scanning any
+func Explain(defs *Defs, root string) ([][]string, error) {
+	err := defs.prepare(root)
+	if err != nil {
+		return nil, err
+	}
+	return defs.explainDir(".")
+}
+
+// prepare applies the options which narrow what's scanned under root
+func (defs *Defs) prepare(root string) error {
+	err := defs.prepareRules(root)
+	if err != nil {
+		return err
+	}
+	if defs.Options.LidderIgnore {
+		content, err := ioutil.ReadFile(filepath.Join(root, ".lidderignore"))
+		if err == nil {
+			err = defs.addIgnoreFile(content)
+		} else if os.IsNotExist(err) {
+			err = nil
+		}
+		if err != nil {
+			return fmt.Errorf(".lidderignore: %s", err)
+		}
+	}
+	return nil
+}
+
+// prepareRules applies the options which narrow the rules, and sets them to
+// match files named relative to root
+func (defs *Defs) prepareRules(root string) error {
+	defs.root = root
+	err := defs.rebaseExpected(root)
+	if err != nil {
+		return err
+	}
+	if len(defs.Options.Tags) != 0 {
+		defs.selectTags(defs.Options.Tags)
+	}
+	if len(defs.Options.Extensions) != 0 {
+		defs.restrictExtensions(defs.Options.Extensions)
+	}
+	return nil
+}
+
+// selectTags keeps only the rules tagged with one of tags
+func (defs *Defs) selectTags(tags []string) {
+	wanted := make(map[string]bool)
+	for _, tag := range tags {
+		wanted[tag] = true
+	}
+
+	var rules []*Rule
+	for _, rule := range defs.Rules {
+		for _, tag := range rule.Tags {
+			if wanted[tag] {
+				rules = append(rules, rule)
+				break
+			}
+		}
+	}
+	defs.Rules = rules
+}
+
+// addIgnoreFile excludes the paths listed by a .lidderignore file, in
+// gitignore syntax relative to the root, from every rule
+func (defs *Defs) addIgnoreFile(content []byte) error {
+	exprs, err := excludeRegexps(content)
+	if err != nil {
+		return err
+	}
+	patterns := make([]*regexp.Regexp, len(exprs))
+	for i, expr := range exprs {
+		patterns[i] = regexp.MustCompile(expr)
+	}
+
+	defs.exclude = append(defs.exclude, patterns...)
+	for _, rule := range defs.Rules {
+		if rule.Exclude == nil {
+			rule.exclude = defs.exclude
+		} else {
+			rule.exclude = append(rule.exclude[:len(rule.exclude):len(rule.exclude)], patterns...)
+		}
+	}
+	return nil
+}
+
+// AddPaths appends regular expressions to the configured include and
+// exclude lists, for a run's sake rather than the config's. Includes go to
+// the rules without their own, while excludes go to every rule, and still
+// win over includes.
+func (defs *Defs) AddPaths(include, exclude []string) error {
+	includes, err := compileRegexps("include", include)
+	if err != nil {
+		return err
+	}
+	excludes, err := compileRegexps("exclude", exclude)
+	if err != nil {
+		return err
+	}
+
+	defs.include = append(defs.include[:len(defs.include):len(defs.include)], includes...)
+	defs.exclude = append(defs.exclude[:len(defs.exclude):len(defs.exclude)], excludes...)
+	for _, rule := range defs.Rules {
+		if rule.Include == nil {
+			rule.include = defs.include
+		}
+		if rule.Exclude == nil {
+			rule.exclude = defs.exclude
+		} else {
+			rule.exclude = append(rule.exclude[:len(rule.exclude):len(rule.exclude)], excludes...)
+		}
+	}
+	return nil
+}
+
+func compileRegexps(list string, exprs []string) ([]*regexp.Regexp, error) {
+	patterns := make([]*regexp.Regexp, len(exprs))
+	for i, expr := range exprs {
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("%s '%s': %s", list, expr, err)
+		}
+		patterns[i] = pattern
+	}
+	return patterns, nil
+}
+
+// restrictExtensions narrows what every rule applies to down to the files
+// with one of exts, which may be given with or without their leading dot.
+// Excludes apply as before.
+func (defs *Defs) restrictExtensions(exts []string) {
+	quoted := make([]string, len(exts))
+	for i, ext := range exts {
+		quoted[i] = regexp.QuoteMeta("." + strings.TrimPrefix(ext, "."))
+	}
+	defs.extension = regexp.MustCompile("(?:" + strings.Join(quoted, "|") + ")$")
+	for _, rule := range defs.Rules {
+		rule.extension = defs.extension
+	}
+}
+
+// explainDir lists, for each rule, the files under dirname it would scan
+func (defs *Defs) explainDir(dirname string) ([][]string, error) {
+	filenames, err := defs.collectFiles(dirname, nil, make(map[string]bool))
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := make([][]string, len(defs.Rules))
+	for i, rule := range defs.Rules {
+		for _, filename := range filenames {
+			if rule.shouldCheck(filename) {
+				scopes[i] = append(scopes[i], filename)
+			}
+		}
+	}
+	return scopes, nil
+}
+
+// collectFiles lists every file under dirname which should be checked. When
+// honoring .gitignore files, ignored directories are pruned without being
+// read, and .git itself is always skipped.
+//
+// Symlinks are skipped unless following them, in which case visited tracks
+// the real path of every directory walked so that each is walked only once,
+// which also breaks cycles.
+func (defs *Defs) collectFiles(dirname string, ignores ignoreList, visited map[string]bool) ([]string, error) {
+	// WalkDir doesn't descend into a symlinked root
+	top, err := filepath.EvalSymlinks(defs.path(dirname))
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		filenames []string
+		// the ignore patterns in effect within each directory walked
+		dirIgnores = make(map[string]ignoreList)
+	)
+	err = filepath.WalkDir(top, func(path string, entry fs.DirEntry, walkErr error) error {
+		if defs.interrupted() {
+			return errInterrupted
+		}
+		rel, err := filepath.Rel(top, path)
+		if err != nil {
+			return err
+		}
+		filename := filepath.Join(dirname, rel)
+		if walkErr != nil {
+			if path == top {
+				return walkErr
+			}
+			// for a directory which can't be read, skipping it
+			return defs.skipUnreadable(filename, walkErr)
+		}
+
+		if path == top {
+			dirIgnores[filename], err = defs.enterDir(filename, path, ignores, visited)
+			return err
+		}
+		parentIgnores := dirIgnores[filepath.Dir(filename)]
+
+		if !defs.Options.Hidden && strings.HasPrefix(entry.Name(), ".") {
+			defs.logf("skipping %s: hidden", filename)
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		mode := entry.Type()
+		if mode&fs.ModeSymlink != 0 && defs.Options.FollowSymlinks {
+			fi, err := os.Stat(path)
+			if err != nil {
+				defs.logf("skipping %s: %s", filename, err)
+				return nil
+			}
+			mode = fi.Mode()
+		}
+		if defs.Options.Gitignore && (entry.Name() == ".git" || parentIgnores.ignored(filename, mode.IsDir())) {
+			defs.logf("skipping %s: ignored by git", filename)
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if mode.IsDir() {
+			if excluded, reason := defs.excludedDir(filename); excluded {
+				defs.logf("skipping %s: %s", filename, reason)
+				if entry.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+		}
+
+		switch {
+		case mode.IsDir() && !entry.IsDir():
+			// a symlink WalkDir won't follow, so walk its target separately
+			nested, err := defs.collectFiles(filename, parentIgnores, visited)
+			filenames = append(filenames, nested...)
+			return err
+		case mode.IsDir():
+			dirIgnores[filename], err = defs.enterDir(filename, path, parentIgnores, visited)
+			return err
+		case mode.IsRegular():
+			if ok, reason := defs.checkFile(filename); ok {
+				filenames = append(filenames, filename)
+			} else {
+				defs.logf("skipping %s: %s", filename, reason)
+			}
+		}
+		return nil
+	})
+	if err == errInterrupted {
+		err = nil
+	}
+	return filenames, err
+}
+
+// errInterrupted stops walking a directory once the run is interrupted, the
+// files found so far being kept
+var errInterrupted = errors.New("interrupted")
+
+// skipUnreadable records err, met reading filename, for Results.Unreadable
+// instead of failing the run, unless Options.Strict is set
+func (defs *Defs) skipUnreadable(filename string, err error) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	err = fmt.Errorf("cannot scan '%s': %s", filename, err)
+	if defs.Options.Strict {
+		return err
+	}
+
+	defs.logf("skipping %s: unreadable", filename)
+	defs.mu.Lock()
+	defer defs.mu.Unlock()
+	defs.unreadable = append(defs.unreadable, err)
+	return nil
+}
+
+// enterDir returns the ignore patterns in effect within a directory about to
+// be walked, or fs.SkipDir when it was already visited through a symlink
+func (defs *Defs) enterDir(dirname, path string, ignores ignoreList, visited map[string]bool) (ignoreList, error) {
+	if defs.Options.FollowSymlinks {
+		// WalkDir never goes through symlinks, so path is a real one
+		real, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		if visited[real] {
+			defs.logf("skipping %s: already visited as %s", dirname, real)
+			return nil, fs.SkipDir
+		}
+		visited[real] = true
+	}
+
+	defs.logf("entering %s", dirname)
+	if !defs.Options.Gitignore {
+		return ignores, nil
+	}
+	return defs.readIgnoreFile(dirname, ignores)
+}
+
+// readIgnoreFile extends ignores with the .gitignore in dirname, if any
+func (defs *Defs) readIgnoreFile(dirname string, ignores ignoreList) (ignoreList, error) {
+	content, err := ioutil.ReadFile(defs.path(filepath.Join(dirname, ".gitignore")))
+	if os.IsNotExist(err) {
+		return ignores, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	nested, err := parseIgnore(dirname, content)
+	if err != nil {
+		return nil, err
+	}
+	// copy so sibling directories don't share each other's patterns
+	return append(ignores[:len(ignores):len(ignores)], nested...), nil
+}
+
+// matchAgainstFiles fans the files out to defs.Options.Jobs workers. Matches are sets
+// keyed by filename, so the outcome doesn't depend on which worker handled
+// which file; errors are reported for the first failing file in list order.
+func (defs *Defs) matchAgainstFiles(filenames []string) error {
+	jobs := defs.Options.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	var (
+		work = make(chan int)
+		errs = make([]error, len(filenames))
+		wg   sync.WaitGroup
+	)
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				errs[i] = defs.matchAgainstFile(filenames[i])
+			}
+		}()
+	}
+	for i := range filenames {
+		if defs.interrupted() {
+			break
+		}
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			err = defs.skipUnreadable(filenames[i], err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ShouldCheck tells whether any rule applies to filename, relative to the root
+func (defs *Defs) ShouldCheck(filename string) bool {
+	ok, _ := defs.checkFile(filepath.Clean(filename))
+	return ok
+}
+
+// checkFile is ShouldCheck, also telling why a file isn't checked
+func (defs *Defs) checkFile(filename string) (bool, string) {
+	if defs.extension != nil && !defs.extension.MatchString(filename) {
+		return false, "extension not given to -ext"
+	}
+	ok, reason := checkPaths(filename, defs.include, defs.exclude)
+	if ok {
+		return true, ""
+	}
+	// rules with their own Include or Exclude may reach further
+	for _, rule := range defs.Rules {
+		if (rule.Include != nil || rule.Exclude != nil) && rule.shouldCheck(filename) {
+			return true, ""
+		}
+	}
+	return false, reason
+}
+
+// excludedDir tells whether every rule excludes dirname, so that it needn't be
+// walked. An exclude is taken to match everything below the directory when it
+// matches it with a trailing separator, such as vendor/, and a path deeper
+// than any which could be found there too, unlike ^build/[^/]*$.
+func (defs *Defs) excludedDir(dirname string) (bool, string) {
+	sep := string(filepath.Separator)
+	dirname += sep
+	deep := dirname + "\x00" + sep + "\x00"
+	excludedBy := func(exclude []*regexp.Regexp) string {
+		for _, pattern := range exclude {
+			if pattern.MatchString(dirname) && pattern.MatchString(deep) {
+				return pattern.String()
+			}
+		}
+		return ""
+	}
+
+	pattern := excludedBy(defs.exclude)
+	if pattern == "" {
+		return false, ""
+	}
+	for _, rule := range defs.Rules {
+		if excludedBy(rule.exclude) == "" {
+			return false, ""
+		}
+	}
+	return true, fmt.Sprintf("matched exclude '%s'", pattern)
+}
+
+// IncludedBy is the index, among the includes Paths lists, of the first one
+// which pulls filename into the rule, or -1 when the rule doesn't apply to it
+func (rule *Rule) IncludedBy(filename string) int {
+	if !rule.shouldCheck(filename) {
+		return -1
+	}
+	for i, include := range rule.include {
+		if include.MatchString(filename) {
+			return i
+		}
+	}
+	return -1
+}
+
+// shouldCheck tells whether the rule applies to filename
+func (rule *Rule) shouldCheck(filename string) bool {
+	if rule.extension != nil && !rule.extension.MatchString(filename) {
+		return false
+	}
+	ok, _ := checkPaths(filename, rule.include, rule.exclude)
+	return ok
+}
+
+func checkPaths(filename string, include, exclude []*regexp.Regexp) (bool, string) {
+	// prioritize exclusions over inclusions
+	// matching any means we don't process the file
+	for _, exclude := range exclude {
+		if exclude.Match([]byte(filename)) {
+			return false, fmt.Sprintf("matched exclude '%s'", exclude)
+		}
+	}
+	// matching any means we process the file
+	for _, include := range include {
+		if include.Match([]byte(filename)) {
+			return true, ""
+		}
+	}
+	return false, "matched no include"
+}
+
+// Results are what a Run found
+type Results struct {
+	Rules []*RuleResult
+	Stats Stats
+	// how many lines were removed from each file, with Options.Fix
+	Fixed map[string]int
+	// how many substitutions rules with Replace made in each file, with
+	// Options.Fix
+	Replaced map[string]int
+	// a unified diff of the fixes to each file, with Options.DryRun instead
+	// of Fixed
+	Diffs map[string]string
+	// why files or directories couldn't be read, which were skipped unless
+	// Options.Strict is set
+	Unreadable []error
+	// the files only partly scanned, as they took longer than
+	// Options.Timeout
+	TimedOut []string
+	// Options.Interrupt was done before all files were scanned, so that
+	// the rules only fared this way in those which were
+	Partial bool
+}
+
+// RuleResult is how a rule fared, as told by Rule.Mismatches
+type RuleResult struct {
+	Rule             *Rule
+	ShouldNotBeThere []string
+	ShouldBeThere    []string
+	// where the pattern was found in each file, only the first time unless
+	// Options.AllMatches is set
+	Matches map[string][]Match
+	// how many lines matched in each file
+	MatchCounts map[string]int
+	// as told by Rule.FileCountMismatch
+	Shortfall int
+	Excess    int
+}
+
+// Failed tells whether the rule has any mismatch
+func (r *RuleResult) Failed() bool {
+	return len(r.ShouldNotBeThere) != 0 || len(r.ShouldBeThere) != 0 || r.Shortfall != 0 || r.Excess != 0
+}
+
+// severities rank the rule severities, the empty one being error
+var severities = map[string]int{"info": 0, "warning": 1, "error": 2, "": 2}
+
+// SeverityOrDefault is the rule's Severity, error when not set
+func (rule *Rule) SeverityOrDefault() string {
+	if rule.Severity == "" {
+		return "error"
+	}
+	return rule.Severity
+}
+
+// IsAtLeast tells whether the rule's severity is severity or a higher one
+func (rule *Rule) IsAtLeast(severity string) bool {
+	return severities[rule.Severity] >= severities[severity]
+}
+
+// Fails tells whether the result should fail the lid test, failOn being
+// both, unexpected or missing
+func (r *RuleResult) Fails(failOn string) bool {
+	switch failOn {
+	case "unexpected":
+		return len(r.ShouldNotBeThere) != 0 || r.Excess != 0
+	case "missing":
+		return len(r.ShouldBeThere) != 0 || r.Shortfall != 0
+	}
+	return r.Failed()
 }
@@ -0,0 +1,124 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lidder
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"unicode/utf8"
+)
+
+// literalSet finds which of many literal strings a line contains in a single
+// pass over it, as an Aho-Corasick automaton, rather than running as many
+// regular expressions
+type literalSet struct {
+	nodes []literalNode
+	// how many literals there are
+	n int
+}
+
+type literalNode struct {
+	next map[byte]int
+	// the node for the longest proper suffix of this one's string which
+	// also starts a literal, the root being 0
+	fail int
+	// the literals ending here, including through fail
+	out []int
+}
+
+// newLiteralSet builds the automaton for literals, which must not be empty,
+// find then telling them apart by their index
+func newLiteralSet(literals []string) *literalSet {
+	s := &literalSet{nodes: []literalNode{{next: make(map[byte]int)}}, n: len(literals)}
+	for i, literal := range literals {
+		node := 0
+		for j := 0; j < len(literal); j++ {
+			next, ok := s.nodes[node].next[literal[j]]
+			if !ok {
+				next = len(s.nodes)
+				s.nodes = append(s.nodes, literalNode{next: make(map[byte]int)})
+				s.nodes[node].next[literal[j]] = next
+			}
+			node = next
+		}
+		s.nodes[node].out = append(s.nodes[node].out, i)
+	}
+
+	// breadth first, so that the nodes failure links lead to, which are
+	// shallower, are done first
+	var queue []int
+	for _, child := range s.nodes[0].next {
+		queue = append(queue, child)
+	}
+	for len(queue) != 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for c, child := range s.nodes[node].next {
+			queue = append(queue, child)
+			fail := s.nodes[node].fail
+			for fail != 0 && !s.has(fail, c) {
+				fail = s.nodes[fail].fail
+			}
+			s.nodes[child].fail = s.nodes[fail].next[c]
+			s.nodes[child].out = append(s.nodes[child].out, s.nodes[s.nodes[child].fail].out...)
+		}
+	}
+	return s
+}
+
+// literalOf is the string pattern matches, when it's a plain, non empty one,
+// without anything like anchors, classes or case folding
+func literalOf(pattern *regexp.Regexp) (string, bool) {
+	re, err := syntax.Parse(pattern.String(), syntax.Perl)
+	if err != nil {
+		return "", false
+	}
+	re = re.Simplify()
+	if re.Op != syntax.OpLiteral || re.Flags&syntax.FoldCase != 0 {
+		return "", false
+	}
+	// which matches invalid UTF-8 as well, unlike its encoding
+	for _, r := range re.Rune {
+		if r == utf8.RuneError {
+			return "", false
+		}
+	}
+	return string(re.Rune), true
+}
+
+func (s *literalSet) has(node int, c byte) bool {
+	_, ok := s.nodes[node].next[c]
+	return ok
+}
+
+// find tells which literals line contains, by their index, being nil when
+// it has none of them
+func (s *literalSet) find(line string) []bool {
+	var found []bool
+	node := 0
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		for node != 0 && !s.has(node, c) {
+			node = s.nodes[node].fail
+		}
+		// back to the root when nothing goes on with c
+		node = s.nodes[node].next[c]
+		for _, literal := range s.nodes[node].out {
+			if found == nil {
+				found = make([]bool, s.n)
+			}
+			found[literal] = true
+		}
+	}
+	return found
+}
@@ -0,0 +1,87 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lidder
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/stretchr/testify/require"
+)
+
+func (s *Zuite) TestLiteralSet() {
+	literals := []string{"he", "she", "his", "hers", "s", "ushers"}
+	set := newLiteralSet(literals)
+	for _, line := range []string{"", "ushers", "this", "h", "shhe", "xyz", "hishers"} {
+		var expected []bool
+		for i, literal := range literals {
+			if strings.Contains(line, literal) {
+				if expected == nil {
+					expected = make([]bool, len(literals))
+				}
+				expected[i] = true
+			}
+		}
+		require.Equal(s.T(), expected, set.find(line), line)
+	}
+}
+
+func (s *Zuite) TestLiteralOf() {
+	for expr, expected := range map[string]string{
+		`os\.Exit`: "os.Exit",
+		`TODO`:     "TODO",
+		`a\+b`:     "a+b",
+	} {
+		literal, ok := literalOf(regexp.MustCompile(expr))
+		require.True(s.T(), ok, expr)
+		require.Equal(s.T(), expected, literal)
+	}
+	for _, expr := range []string{``, `^TODO`, `\bpanic\b`, `(?i)todo`, `a|b`, `a.b`, `colou?r`, `\x{FFFD}`} {
+		_, ok := literalOf(regexp.MustCompile(expr))
+		require.False(s.T(), ok, expr)
+	}
+}
+
+func (s *Zuite) TestLiteralRulesMatchAsRegexps() {
+	var config strings.Builder
+	config.WriteString("include: [\\.go$]\nrules:\n")
+	for _, pattern := range []string{`os\.Exit`, `Exit`, `fmt\.Print`, `Print`, `^package`, `x`} {
+		fmt.Fprintf(&config, "  - pattern: '%s'\n", pattern)
+	}
+	config.WriteString("  - pattern: Println\n    unless: nolint\n")
+	d, err := Parse([]byte(config.String()))
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 6, d.literals.n)
+
+	files := map[string]string{
+		"a.go": "package a\nfunc f() { os.Exit(1) }\n",
+		"b.go": "fmt.Println(x)\nfmt.Println(y) // nolint\n",
+		"c.go": "// nothing\n",
+	}
+	d.Options.AllMatches = true
+	results, err := MatchStrings(d, files)
+	require.NoError(s.T(), err)
+
+	for _, result := range results.Rules {
+		for filename, content := range files {
+			var expected []Match
+			for i, line := range strings.Split(strings.TrimSuffix(content, "\n"), "\n") {
+				if result.Rule.matchLine(line) {
+					expected = append(expected, Match{Line: i + 1, Text: line})
+				}
+			}
+			require.Equal(s.T(), expected, result.Matches[filename], "%s in %s", result.Rule.Pattern, filename)
+		}
+	}
+}